@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/TJN25/histgrep/utils"
+	"github.com/spf13/cobra"
+)
+
+// configCmd groups the commands for inspecting and editing histgrep.toml
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect, check and edit the settings in histgrep.toml.",
+	Long: `Inspect, check and edit the settings in histgrep.toml.
+Settings are named section.key, e.g. search.jobs, and can be overridden with
+environment variables named HISTGREP_SECTION_KEY, e.g. HISTGREP_SEARCH_JOBS.`,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show every setting and where its value came from.",
+	Long: `Show every setting and where its value came from: the default, the config file, an environment
+variable or a flag. The search flags given here (-c, -f, -p, -j, -n) are applied as they would be for histgrep s.`,
+	Args: cobra.NoArgs,
+	Run:  configShowRun,
+}
+
+var configPathCmd = &cobra.Command{
+	Use:   "path",
+	Short: "Show where the config files are looked for and which are used.",
+	Args:  cobra.NoArgs,
+	Run:   configPathRun,
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check histgrep.toml and the formats for mistakes.",
+	Args:  cobra.NoArgs,
+	Run:   configValidateRun,
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get KEY",
+	Short: "Print the value of a setting.",
+	Args:  cobra.ExactArgs(1),
+	Run:   configGetRun,
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set KEY VALUE",
+	Short: "Change a setting in histgrep.toml.",
+	Long: `Change a setting in histgrep.toml, keeping the rest of the file as it is.
+The file is created in the config directory if it does not exist yet.`,
+	Args: cobra.ExactArgs(2),
+	Run:  configSetRun,
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd, configPathCmd, configValidateCmd, configGetCmd, configSetCmd)
+
+	for _, cmd := range []*cobra.Command{configShowCmd, configGetCmd} {
+		cmd.Flags().BoolP("case-sensitive", "c", false, "Use case sensitive search")
+		cmd.Flags().BoolP("no-color", "f", false, "Do not include colors in output")
+		cmd.Flags().BoolP("pager", "p", false, "Display output in pager (Bubble Tea)")
+		cmd.Flags().IntP("jobs", "j", 0, "Number of files to search in parallel")
+		cmd.Flags().StringP("name", "n", "", "Name of saved format")
+	}
+	configCmd.PersistentFlags().CountP("verbose", "v", "Level of verbosity (0-5) default (0)")
+}
+
+// Load the settings a search would use.
+func loadConfigForCommand(cmd *cobra.Command) *utils.Config {
+	verbosity, _ := cmd.Flags().GetCount("verbose")
+	utils.SetVerbosity(verbosity)
+	config, err := utils.LoadConfigFile()
+	if err != nil {
+		utils.Log.Fatalf(1, "Loading histgrep.toml failed: %v\n", err)
+	}
+	return config
+}
+
+// Apply the search flags that override settings, as histgrep s does.
+func applyConfigFlags(cmd *cobra.Command, config *utils.Config) {
+	flags := cmd.Flags()
+	set := func(key string, value interface{}) {
+		if err := config.Set(key, fmt.Sprint(value), utils.SourceFlag); err != nil {
+			utils.Log.Fatalf(1, "%v\n", err)
+		}
+	}
+	if flags.Changed("case-sensitive") {
+		caseSensitive, _ := flags.GetBool("case-sensitive")
+		set("search.case_sensitive", caseSensitive)
+	}
+	if flags.Changed("no-color") {
+		noColor, _ := flags.GetBool("no-color")
+		set("display.color_enabled", !noColor)
+	}
+	if flags.Changed("pager") {
+		pager, _ := flags.GetBool("pager")
+		set("display.pager_enabled", pager)
+	}
+	if flags.Changed("jobs") {
+		jobs, _ := flags.GetInt("jobs")
+		set("search.jobs", jobs)
+	}
+	if flags.Changed("name") {
+		name, _ := flags.GetString("name")
+		set("search.default_name", name)
+	}
+}
+
+func configShowRun(cmd *cobra.Command, args []string) {
+	config := loadConfigForCommand(cmd)
+	applyConfigFlags(cmd, config)
+	if config.Path() != "" {
+		fmt.Printf("# %v\n", config.Path())
+	} else {
+		fmt.Println("# No histgrep.toml found, using the defaults and environment")
+	}
+	for _, setting := range config.Settings() {
+		fmt.Printf("%-28v %-12v (%v)\n", setting.Key, setting.Value, setting.Source)
+	}
+}
+
+func configPathRun(cmd *cobra.Command, args []string) {
+	verbosity, _ := cmd.Flags().GetCount("verbose")
+	utils.SetVerbosity(verbosity)
+	for _, file := range []string{"histgrep.toml", "formats.json"} {
+		fmt.Printf("%v:\n", file)
+		found := false
+		for _, path := range utils.DataPaths(file) {
+			marker := " "
+			if _, err := os.Stat(path); err == nil {
+				if !found {
+					marker = "*"
+				} else {
+					marker = "-"
+				}
+				found = true
+			}
+			fmt.Printf("  %v %v\n", marker, path)
+		}
+		if !found && file == "histgrep.toml" {
+			fmt.Printf("    (not found, histgrep config set creates %v)\n", utils.NewDataPath(file))
+		} else if !found {
+			fmt.Println("    (not found)")
+		}
+	}
+	fmt.Println("\n* in use, - found but hidden by the file above it")
+}
+
+func configGetRun(cmd *cobra.Command, args []string) {
+	config := loadConfigForCommand(cmd)
+	applyConfigFlags(cmd, config)
+	value, err := config.Get(args[0])
+	if err != nil {
+		utils.Log.Fatalf(1, "%v\n", err)
+	}
+	fmt.Println(value)
+}
+
+func configSetRun(cmd *cobra.Command, args []string) {
+	verbosity, _ := cmd.Flags().GetCount("verbose")
+	utils.SetVerbosity(verbosity)
+	path, err := utils.GetDataPath("histgrep.toml")
+	if err != nil {
+		path = utils.NewDataPath("histgrep.toml")
+	}
+	if err := utils.WriteConfigValue(path, args[0], args[1]); err != nil {
+		utils.Log.Fatalf(1, "Setting %v failed: %v\n", args[0], err)
+	}
+	fmt.Printf("Set %v = %v in %v\n", args[0], args[1], path)
+}
+
+func configValidateRun(cmd *cobra.Command, args []string) {
+	config := loadConfigForCommand(cmd)
+	var problems []string
+	for _, key := range config.UnknownKeys() {
+		problems = append(problems, fmt.Sprintf("unknown setting %q", key))
+	}
+	if config.Search.Jobs < 0 {
+		problems = append(problems, "search.jobs must not be negative")
+	}
+	if config.DefaultLogs.FilePattern != "EMPTY" {
+		if _, err := filepath.Match(config.DefaultLogs.FilePattern, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("default_logs.file_pattern %q is not a valid pattern", config.DefaultLogs.FilePattern))
+		}
+		if info, err := os.Stat(config.DefaultLogs.Directory); err != nil || !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("default_logs.directory %q is not a directory", config.DefaultLogs.Directory))
+		}
+	}
+	if err := utils.ValidateRecordRules(config); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	formatMap, err := utils.LoadFormats(config)
+	broken, _ := err.(utils.FormatErrors)
+	if err != nil && broken == nil {
+		problems = append(problems, err.Error())
+	} else {
+		defaultName := DefaultFormatName(config)
+		if _, ok := formatMap[defaultName]; !ok && broken[defaultName] == nil {
+			problems = append(problems, fmt.Sprintf("search.default_name: format %q not found", defaultName))
+		}
+		names := make([]string, 0, len(formatMap)+len(broken))
+		for name := range formatMap {
+			names = append(names, name)
+		}
+		for name := range broken {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err, ok := broken[name]; ok {
+				problems = append(problems, err.Error())
+				continue
+			}
+			format_data := formatMap[name]
+			for _, problem := range utils.ValidateFormat(&format_data) {
+				problems = append(problems, fmt.Sprintf("format %v: %v", name, problem))
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		fmt.Println("ok")
+		return
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	os.Exit(1)
+}
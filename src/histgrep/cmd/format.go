@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/TJN25/histgrep/hsdata"
+	"github.com/TJN25/histgrep/utils"
+	"github.com/spf13/cobra"
+)
+
+// formatCmd groups the commands for checking formats
+var formatCmd = &cobra.Command{
+	Use:   "format",
+	Short: "Check and try out formats.",
+	Long:  `Check formats for mistakes and see how they read and show a line.`,
+}
+
+var formatTestCmd = &cobra.Command{
+	Use:   "test -n NAME \"sample line\"",
+	Short: "Show how a format splits and renders a sample line.",
+	Long: `Show the fields a format reads from a sample line and the line as a search would print it.
+Lines that are not shown (because of Excludes or the format's on_mismatch policy) are reported.`,
+	Args: cobra.ExactArgs(1),
+	Run:  formatTestRun,
+}
+
+var formatValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check formats for mistakes.",
+	Long: `Check every format (or the one given with -n) for unknown keys, separator counts that do not fit the keys,
+unknown colours and invalid regular expressions. Exits with status 1 if any are found.`,
+	Args: cobra.NoArgs,
+	Run:  formatValidateRun,
+}
+
+func init() {
+	rootCmd.AddCommand(formatCmd)
+	formatCmd.AddCommand(formatTestCmd)
+	formatCmd.AddCommand(formatValidateCmd)
+
+	formatTestCmd.Flags().StringP("name", "n", "", "Name of the format to test")
+	formatTestCmd.Flags().BoolP("no-color", "f", false, "Do not include colors in output")
+	formatTestCmd.MarkFlagRequired("name")
+	formatValidateCmd.Flags().StringP("name", "n", "", "Only check this format")
+	formatCmd.PersistentFlags().CountP("verbose", "v", "Level of verbosity (0-5) default (0)")
+}
+
+// Load the formats and the named one, if a name is given. Formats that could
+// not be resolved are returned separately.
+func loadFormat(name string) (hsdata.FormatMap, hsdata.FormattingData, utils.FormatErrors) {
+	config, err := utils.LoadConfigFile()
+	if err != nil {
+		utils.Log.Fatalf(1, "Loading histgrep.toml failed: %v\n", err)
+	}
+	formatMap, err := utils.LoadFormats(config)
+	broken, _ := err.(utils.FormatErrors)
+	if err := utils.FormatLoadError(err, name); err != nil {
+		utils.Log.Fatalf(1, "Loading formats failed: %v\n", err)
+	}
+	format_data, ok := formatMap[name]
+	if name != "" && !ok {
+		utils.Log.Fatalf(1, "Format not found: %v\n", name)
+	}
+	return formatMap, format_data, broken
+}
+
+func formatTestRun(cmd *cobra.Command, args []string) {
+	verbosity, _ := cmd.Flags().GetCount("verbose")
+	utils.SetVerbosity(verbosity)
+	name, _ := cmd.Flags().GetString("name")
+	noColor, _ := cmd.Flags().GetBool("no-color")
+	_, format_data, _ := loadFormat(name)
+
+	for _, problem := range utils.ValidateFormat(&format_data) {
+		fmt.Printf("Warning: %v\n", problem)
+	}
+	result := utils.TestFormat(args[0], &format_data, noColor)
+	fmt.Println("Fields:")
+	for _, field := range result.Fields {
+		fmt.Printf("    %v: %q\n", field[0], field[1])
+	}
+	fmt.Println("Output:")
+	if result.Note != "" {
+		fmt.Printf("    (%v)\n", result.Note)
+	} else {
+		fmt.Printf("    %v\n", result.Output)
+	}
+}
+
+func formatValidateRun(cmd *cobra.Command, args []string) {
+	verbosity, _ := cmd.Flags().GetCount("verbose")
+	utils.SetVerbosity(verbosity)
+	name, _ := cmd.Flags().GetString("name")
+	formatMap, _, broken := loadFormat(name)
+
+	names := []string{name}
+	if name == "" {
+		names = names[:0]
+		for formatName := range formatMap {
+			names = append(names, formatName)
+		}
+		for formatName := range broken {
+			names = append(names, formatName)
+		}
+		sort.Strings(names)
+	}
+	failed := false
+	for _, formatName := range names {
+		format_data := formatMap[formatName]
+		problems := utils.ValidateFormat(&format_data)
+		if err, ok := broken[formatName]; ok {
+			problems = []error{err}
+		}
+		if len(problems) == 0 {
+			fmt.Printf("%v: ok\n", formatName)
+			continue
+		}
+		failed = true
+		fmt.Printf("%v:\n", formatName)
+		for _, problem := range problems {
+			fmt.Printf("    %v\n", problem)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
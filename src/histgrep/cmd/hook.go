@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/TJN25/histgrep/utils"
+	"github.com/spf13/cobra"
+)
+
+// hookCmd prints shell code that logs each command with its context
+var hookCmd = &cobra.Command{
+	Use:   "hook [zsh|bash|fish]",
+	Short: "Print a shell hook that logs each command with its exit status and duration.",
+	Long: `Print shell code that runs histgrep record after every command, logging the date and time it started,
+the host, the directory it ran in, its exit status, how many seconds it took and the command itself.
+Lines go to the default_logs directory of histgrep.toml, in files named by default_logs.file_pattern,
+in the layout of the built-in "rich" format (set search.default_name = "rich" to search them by default).
+Load it from your shell's startup file, e.g. eval "$(histgrep hook zsh)" or histgrep hook fish | source.
+The shell defaults to the one in $SHELL.`,
+	Args:      cobra.MaximumNArgs(1),
+	ValidArgs: utils.HookShells,
+	Run:       hookRun,
+}
+
+func init() {
+	rootCmd.AddCommand(hookCmd)
+
+	hookCmd.PersistentFlags().CountP("verbose", "v", "Level of verbosity (0-5) default (0)")
+}
+
+func hookRun(cmd *cobra.Command, args []string) {
+	verbosity, _ := cmd.Flags().GetCount("verbose")
+	utils.SetVerbosity(verbosity)
+
+	shell := utils.CurrentShell()
+	if len(args) > 0 {
+		shell = args[0]
+	}
+	hook, err := utils.ShellHook(shell)
+	if err != nil {
+		utils.Log.Fatalf(1, "%v\n", err)
+	}
+	fmt.Print(hook)
+}
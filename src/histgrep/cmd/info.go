@@ -4,6 +4,7 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/TJN25/histgrep/hsdata"
 	"github.com/TJN25/histgrep/utils"
@@ -42,6 +43,7 @@ func infoRun(cmd *cobra.Command, args []string) {
 
 	data := hsdata.InfoData{}
 	infoGetArgs(cmd, &data)
+	utils.SetVerbosity(data.Verbosity)
 
 	switch data.Verbosity {
 	case 0:
@@ -68,19 +70,27 @@ func infoRun(cmd *cobra.Command, args []string) {
 }
 
 func DoFormats(data *hsdata.InfoData) {
-	file, err := utils.GetDataPath("formats.json")
+	config, err := utils.LoadConfigFile()
 	if err != nil {
-		fmt.Println("Please create the config directory ($XDG_CONFIG_HOME/histgrep/ or $HOME/.histgrep/)")
+		fmt.Println(err)
 		os.Exit(1)
 	}
-	log.Info(fmt.Sprintf("Using config file %v", file))
-	formatMap := hsdata.FormatMap{}
-	utils.FetchFormatting(file, &formatMap)
+	formatMap, err := utils.LoadFormats(config)
 	if data.Name != "-" {
+		if err := utils.FormatLoadError(err, data.Name); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		fmt.Println("\n--- Format ---\n ")
 		PrintOneFormat(formatMap, data.Name)
 		return
 	}
+	if broken, ok := err.(utils.FormatErrors); ok {
+		utils.Log.Fprintf(os.Stderr, "Warning: %v\n", broken)
+	} else if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	fmt.Println("\n--- Formats ---\n ")
 	PrintFormats(formatMap, data.Names_only)
 }
@@ -95,31 +105,41 @@ func PrintFormats(fm hsdata.FormatMap, names_only bool) {
 	}
 }
 
+// Print a format with everything it inherits through "extends" filled in.
 func PrintOneFormat(fm hsdata.FormatMap, name string) {
-	v, _ := fm[name]
-	fmt.Printf("Name: %v \n    input: \"%v\" \n    output: \"%v\"\n", name, v.Input, v.Output)
+	v, ok := fm[name]
+	if !ok {
+		fmt.Printf("Format not found: %v\n", name)
+		os.Exit(1)
+	}
+	resolved, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("Name: %v\n%s\n", name, resolved)
 }
 
+// Show the format searches use without -n.
 func DoDefaults(data *hsdata.InfoData) {
-	file, err := utils.GetDataPath("defaults.json")
+	config, err := utils.LoadConfigFile()
 	if err != nil {
-		fmt.Println("Please create the config directory ($XDG_CONFIG_HOME/histgrep/ or $HOME/.histgrep/)")
+		fmt.Println(err)
 		os.Exit(1)
 	}
-	config_file, err := utils.GetDataPath("formats.json")
-	if err != nil {
-		fmt.Println("Please create the config directory ($XDG_CONFIG_HOME/histgrep/ or $HOME/.histgrep/)")
+	formatMap, err := utils.LoadFormats(config)
+	name := DefaultFormatName(config)
+	if err := utils.FormatLoadError(err, name); err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
-	log.Info(fmt.Sprintf("Using defaults file %v", file))
-	log.Info(fmt.Sprintf("Using config file %v", config_file))
 	fmt.Println("\n--- Defaults ---\n ")
-	formatMap := hsdata.FormatMap{}
-	defaults := hsdata.DefaultsData{}
-	utils.FetchFormatting(config_file, &formatMap)
-	defaultsConfig := formatMap.Get(defaults.Name)
-
-	PrintDefaults(defaultsConfig, defaults.Name, data.Names_only)
+	defaultsConfig, ok := formatMap[name]
+	if !ok {
+		fmt.Printf("Default format not found: %v\n", name)
+		return
+	}
+	PrintDefaults(defaultsConfig, name, data.Names_only)
 }
 
 func PrintDefaults(fm hsdata.FormattingData, name string, names_only bool) {
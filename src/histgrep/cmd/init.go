@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/TJN25/histgrep/utils"
+	"github.com/spf13/cobra"
+)
+
+// initCmd sets up the config files and shell logging for a new user
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Write starter config files and set up shell logging.",
+	Long: `Write a starter histgrep.toml and formats.json to the config directory and print a hook for your shell
+that runs histgrep record after every command, logging it with its exit status and duration to a daily file in the log directory.
+The starter "history" format extends the built-in "rich" format that reads these files.
+Use --append to add the hook to your shell's startup file instead of printing it.
+Existing config files are left alone unless --force is given.`,
+	Args: cobra.NoArgs,
+	Run:  initRun,
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+
+	initCmd.Flags().StringP("shell", "s", "", "Shell to set up (zsh, bash or fish; default: from $SHELL)")
+	initCmd.Flags().StringP("dir", "d", "", "Directory to write the config files to (default: the first config directory that is set)")
+	initCmd.Flags().StringP("log-dir", "l", "~/.logs", "Directory the shell hook writes logs to")
+	initCmd.Flags().BoolP("append", "a", false, "Append the hook to the shell's startup file")
+	initCmd.Flags().BoolP("force", "", false, "Overwrite existing config files")
+	initCmd.PersistentFlags().CountP("verbose", "v", "Level of verbosity (0-5) default (0)")
+}
+
+const starterFormats = `{
+    "history":{
+        "extends":"rich",
+        "Excludes":{
+            "command":{
+                "equals":["ls","ll","pwd","clear","exit"]
+            }
+        }
+    }
+}
+`
+
+func starterConfig(logDir string) string {
+	return fmt.Sprintf(`# Written by histgrep init. See histgrep config --help.
+[default_logs]
+directory = %q
+file_pattern = %q
+
+[search]
+case_sensitive = false
+default_name = "history"
+jobs = 0
+
+[display]
+color_enabled = true
+pager_enabled = false
+`, logDir, utils.InitFilePattern)
+}
+
+func initRun(cmd *cobra.Command, args []string) {
+	verbosity, _ := cmd.Flags().GetCount("verbose")
+	utils.SetVerbosity(verbosity)
+	shell, _ := cmd.Flags().GetString("shell")
+	dir, _ := cmd.Flags().GetString("dir")
+	logDir, _ := cmd.Flags().GetString("log-dir")
+	appendHook, _ := cmd.Flags().GetBool("append")
+	force, _ := cmd.Flags().GetBool("force")
+
+	if shell == "" {
+		shell = utils.CurrentShell()
+	}
+	hook, err := utils.ShellHook(shell)
+	if err != nil {
+		utils.Log.Fatalf(1, "%v (choose one with --shell)\n", err)
+	}
+	if dir == "" {
+		dir = filepath.Dir(utils.NewDataPath("histgrep.toml"))
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		utils.Log.Fatalf(1, "Creating %v failed: %v\n", dir, err)
+	}
+	writeStarterFile(filepath.Join(dir, "histgrep.toml"), starterConfig(strings.TrimSuffix(logDir, "/")+"/"), force)
+	writeStarterFile(filepath.Join(dir, "formats.json"), starterFormats, force)
+	if !isConfigDir(dir) {
+		fmt.Printf("Set HISTGREP_CONFIG_PATH=%v so histgrep finds these files\n", dir)
+	}
+	if err := os.MkdirAll(expandHome(logDir), 0o755); err != nil {
+		utils.Log.Fatalf(1, "Creating %v failed: %v\n", logDir, err)
+	}
+
+	if !appendHook {
+		rcFile, _ := utils.ShellRCFile(shell)
+		fmt.Printf("\nAdd this to %v (or run histgrep init --append) to log your commands:\n\n%v", rcFile, hook)
+		return
+	}
+	rcFile, err := utils.ShellRCFile(shell)
+	if err != nil {
+		utils.Log.Fatalf(1, "%v\n", err)
+	}
+	added, err := utils.AppendHook(rcFile, hook)
+	if err != nil {
+		utils.Log.Fatalf(1, "Adding the hook to %v failed: %v\n", rcFile, err)
+	}
+	if added {
+		fmt.Printf("Added the %v hook to %v. Start a new shell to begin logging.\n", shell, rcFile)
+	} else {
+		fmt.Printf("%v already has a histgrep hook\n", rcFile)
+	}
+}
+
+func writeStarterFile(path string, contents string, force bool) {
+	if _, err := os.Stat(path); err == nil && !force {
+		fmt.Printf("Keeping existing %v (use --force to replace it)\n", path)
+		return
+	}
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		utils.Log.Fatalf(1, "Writing %v failed: %v\n", path, err)
+	}
+	fmt.Printf("Wrote %v\n", path)
+}
+
+// Whether histgrep looks for config files in dir.
+func isConfigDir(dir string) bool {
+	for _, path := range utils.DataPaths("histgrep.toml") {
+		if filepath.Clean(filepath.Dir(path)) == filepath.Clean(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, strings.TrimPrefix(path, "~"))
+		}
+	}
+	return path
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/TJN25/histgrep/utils"
+	"github.com/spf13/cobra"
+)
+
+// recordCmd appends a command to today's log
+var recordCmd = &cobra.Command{
+	Use:   "record [flags] -- COMMAND...",
+	Short: "Append a command to today's log.",
+	Long: `Append a command to today's log file in the default_logs directory, in the layout of the built-in "rich" format.
+This is what the hooks from histgrep hook run after each command.
+Commands matching a pattern in record.ignore are skipped and text matching record.redact is replaced before anything is written.
+Newlines in the command are written as \n and backslashes as \\, and the file is locked while the line is added so terminals writing at the same time do not mix their lines.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  recordRun,
+}
+
+func init() {
+	rootCmd.AddCommand(recordCmd)
+
+	recordCmd.Flags().StringP("cwd", "d", "", "Directory the command ran in (default: the current directory)")
+	recordCmd.Flags().IntP("exit", "e", 0, "Exit status of the command")
+	recordCmd.Flags().IntP("duration", "t", 0, "How many seconds the command took")
+	recordCmd.Flags().StringP("shell", "s", "", "Shell the command ran in, used for {SHELL} in the file pattern (default: from $SHELL)")
+	recordCmd.PersistentFlags().CountP("verbose", "v", "Level of verbosity (0-5) default (0)")
+}
+
+func recordRun(cmd *cobra.Command, args []string) {
+	verbosity, _ := cmd.Flags().GetCount("verbose")
+	utils.SetVerbosity(verbosity)
+	dir, _ := cmd.Flags().GetString("cwd")
+	status, _ := cmd.Flags().GetInt("exit")
+	duration, _ := cmd.Flags().GetInt("duration")
+	shell, _ := cmd.Flags().GetString("shell")
+
+	if shell == "" {
+		shell = utils.CurrentShell()
+	}
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	config := logConfig()
+	entry := utils.RecordEntry{
+		Start:    time.Now().Add(-time.Duration(duration) * time.Second),
+		Host:     utils.HostName(),
+		Dir:      dir,
+		Status:   status,
+		Duration: duration,
+		Command:  strings.Join(args, " "),
+	}
+	recorded, err := utils.RecordCommand(config, shell, entry)
+	if err != nil {
+		utils.Log.Fatalf(1, "Recording the command failed: %v\n", err)
+	}
+	if !recorded {
+		utils.Log.Infof("Not recording %q\n", entry.Command)
+	}
+}
+
+// The config to write logs with, using the histgrep init file pattern when
+// none is set.
+func logConfig() *utils.Config {
+	config, err := utils.LoadConfigFile()
+	if err != nil {
+		utils.Log.Fatalf(1, "%v\n", err)
+	}
+	if config.DefaultLogs.FilePattern == "" || config.DefaultLogs.FilePattern == "EMPTY" {
+		config.DefaultLogs.FilePattern = utils.InitFilePattern
+	}
+	return config
+}
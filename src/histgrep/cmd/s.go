@@ -9,6 +9,7 @@ import (
 	"io"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/TJN25/histgrep/hsdata"
 	"github.com/TJN25/histgrep/utils"
@@ -29,10 +30,21 @@ func init() {
 	sCmd.Flags().StringP("output", "o", "stdout", "Output file (leave blank for stdout)")
 	sCmd.Flags().StringP("name", "n", "-", "Name of saved format (add with histgrep add-format -n [name] -i [input] -o [output])")
 	sCmd.Flags().BoolP("case-sensitive", "c", false, "Use case sensitive search")
+	sCmd.Flags().BoolP("regex", "E", false, "Treat every search term as a regular expression (or prefix single terms with re:)")
 	sCmd.Flags().BoolP("no-color", "f", false, "Do not include colors in output")
 	sCmd.Flags().BoolP("pager", "p", false, "Display output in pager (Bubble Tea)")
 	sCmd.Flags().BoolP("numbered", "", false, "Include line numbers in output")
+	sCmd.Flags().BoolP("with-filename", "H", false, "Show the file each line came from (output key FILE)")
+	sCmd.Flags().BoolP("line-number", "", false, "Show the line number of each line in its file (output key LINENO)")
 	sCmd.Flags().StringP("exclude", "x", "SKIPEXCLUDE", "Exclude specific terms from output")
+	sCmd.Flags().StringP("since", "", "", "Only show lines at or after this time (e.g. 2024-01-31, \"2024-01-31 15:04\", 3h, yesterday, \"last monday\")")
+	sCmd.Flags().StringP("until", "", "", "Only show lines at or before this time (same values as --since; a day such as 2024-01-31 includes that whole day)")
+	sCmd.Flags().StringP("time-layout", "", "", "Go time layout of the format's timestamp field (or \"unix\"), overrides Input.timestamp_layout")
+	sCmd.Flags().IntP("after-context", "A", 0, "Show NUM lines after each match")
+	sCmd.Flags().IntP("before-context", "B", 0, "Show NUM lines before each match")
+	sCmd.Flags().IntP("context", "C", 0, "Show NUM lines before and after each match")
+	sCmd.Flags().StringP("output-format", "", utils.OutputText, "Write results as text, json, ndjson, csv or tsv")
+	sCmd.Flags().IntP("jobs", "j", 0, "Number of files to search in parallel (default: number of CPUs)")
 	sCmd.PersistentFlags().CountP("verbose", "v", "Level of verbosity (0-5) default (0)")
 }
 
@@ -41,6 +53,9 @@ func sRun(cmd *cobra.Command, args []string) {
 	verbosity, _ := cmd.PersistentFlags().GetCount("verbose")
 	utils.SetVerbosity(verbosity)
 	config := sGetArgs(cmd, &data)
+	if !data.UseRegex {
+		data.Terms = utils.QueryArgs(data.Terms)
+	}
 	utils.Log.Infof("\n    Running search with: \n    files: %v -> %v\n    Terms: %v\n    Format: %v\n", data.InputFile, data.OutputFile, data.Terms, data.FormatData)
 	utils.Log.Tracef("Formatting input: %+v\n", data)
 	utils.Log.Debugf("HsData.Input_file: %s\n", data.InputFile)
@@ -53,7 +68,12 @@ func sRun(cmd *cobra.Command, args []string) {
 	utils.Log.Debugf("HsData.NoColor: %t\n", data.NoColor)
 	utils.Log.Debugf("HsData.UsePager: %t\n", data.UsePager)
 	utils.Log.Debugf("HsData.IncludeNumbers: %t\n", data.IncludeNumbers)
+	utils.Log.Debugf("HsData.WithFilename: %t, HsData.LineNumbers: %t\n", data.WithFilename, data.LineNumbers)
 	utils.Log.Debugf("HsData.CaseSensitive: %t\n", data.CaseSensitive)
+	utils.Log.Debugf("HsData.UseRegex: %t\n", data.UseRegex)
+	utils.Log.Debugf("HsData.Jobs: %d\n", data.Jobs)
+	utils.Log.Debugf("HsData.ContextBefore: %d, HsData.ContextAfter: %d\n", data.ContextBefore, data.ContextAfter)
+	utils.Log.Debugf("HsData.Since: %v, HsData.Until: %v\n", data.Since, data.Until)
 	DoFormatting(&data)
 	RunLoopFile(&data, config)
 	// SaveHistory(&data)
@@ -73,24 +93,60 @@ func sGetArgs(cmd *cobra.Command, data *hsdata.HsData) *utils.Config {
 	if cmd.Flags().Changed("case-sensitive") {
 		data.CaseSensitive, _ = cmd.Flags().GetBool("case-sensitive")
 	}
+	data.UseRegex, _ = cmd.Flags().GetBool("regex")
 	data.IncludeNumbers, _ = cmd.Flags().GetBool("numbered")
+	data.WithFilename, _ = cmd.Flags().GetBool("with-filename")
+	data.LineNumbers, _ = cmd.Flags().GetBool("line-number")
+	data.TimeLayout, _ = cmd.Flags().GetString("time-layout")
+	data.OutputFormat, _ = cmd.Flags().GetString("output-format")
+	if err := utils.ValidateOutputFormat(data.OutputFormat); err != nil {
+		utils.Log.Fatalf(1, "Invalid --output-format: %v\n", err)
+	}
+	if cmd.Flags().Changed("jobs") {
+		data.Jobs, _ = cmd.Flags().GetInt("jobs")
+	}
+	context, _ := cmd.Flags().GetInt("context")
+	data.ContextBefore, data.ContextAfter = context, context
+	if cmd.Flags().Changed("before-context") {
+		data.ContextBefore, _ = cmd.Flags().GetInt("before-context")
+	}
+	if cmd.Flags().Changed("after-context") {
+		data.ContextAfter, _ = cmd.Flags().GetInt("after-context")
+	}
+	if data.ContextBefore < 0 || data.ContextAfter < 0 {
+		utils.ErrorExit("Context line counts must not be negative")
+	}
+	now := time.Now()
+	since, _ := cmd.Flags().GetString("since")
+	if since != "" {
+		t, err := utils.ParseTimeValue(since, now)
+		if err != nil {
+			utils.Log.Fatalf(1, "Invalid --since: %v\n", err)
+		}
+		data.Since = t
+	}
+	until, _ := cmd.Flags().GetString("until")
+	if until != "" {
+		t, err := utils.ParseUntilValue(until, now)
+		if err != nil {
+			utils.Log.Fatalf(1, "Invalid --until: %v\n", err)
+		}
+		data.Until = t
+	}
 	exclude, _ := cmd.Flags().GetString("exclude")
 	if exclude == "SKIPEXCLUDE" {
 		data.ExcludeTerms = []string{}
 	} else {
-		data.ExcludeTerms = strings.Split(exclude, " ")
+		data.ExcludeTerms = []string{exclude}
 	}
 	if data.Name == "-" {
 		data.FormatData = UseDefaults(data, config)
 		utils.Log.Debugf("%+v\n", data.FormatData)
 	} else {
-		file, err := utils.GetDataPath("formats.json")
-		if err != nil {
-			utils.Log.Println("Please create the config directory ($XDG_CONFIG_HOME/histgrep/ or $HOME/.histgrep/)")
-			os.Exit(1)
+		formatMap, err := utils.LoadFormats(config)
+		if err := utils.FormatLoadError(err, data.Name); err != nil {
+			utils.Log.Fatalf(1, "Loading formats failed: %v\n", err)
 		}
-		formatMap := hsdata.FormatMap{}
-		utils.FetchFormatting(file, &formatMap)
 		format_data, ok := formatMap[data.Name]
 		if ok {
 			data.FormatData = format_data
@@ -100,6 +156,11 @@ func sGetArgs(cmd *cobra.Command, data *hsdata.HsData) *utils.Config {
 		data.FormatData = formatMap[data.Name]
 		utils.Log.Tracef("%+v\n", formatMap)
 	}
+	if data.Name != "-" {
+		warnFormatProblems(data.Name, &data.FormatData)
+	} else {
+		warnFormatProblems(DefaultFormatName(config), &data.FormatData)
+	}
 	utils.Log.Tracef("Args data: %+v\n", data)
 	return config
 }
@@ -251,16 +312,22 @@ func GetFormatPositons(curr string, format_data *[]hsdata.FormatPosition) {
 func RunLoopFile(data *hsdata.HsData, config *utils.Config) {
 	var err error
 	line := hsdata.HsLine{}
+	defer func() {
+		if reader, ok := data.Reader.(*utils.StreamInput); ok {
+			reader.Close()
+		}
+	}()
 	if data.UsePager {
 		formatted_lines, err := utils.LoopFile(data, utils.SaveLine, line)
 		if err != nil {
-			utils.Log.Panicf("Running LoopFile failed: %v\n", err)
+			utils.Log.Fatalf(1, "Running LoopFile failed: %v\n", err)
 		}
 		utils.ViewFileWithPager(formatted_lines, data, line, config)
 	} else if data.OutputFile == "stdout" {
 		_, err = utils.LoopFile(data, utils.PrintLine, line)
 	} else {
-		f, err := os.Create(data.OutputFile)
+		var f *os.File
+		f, err = os.Create(data.OutputFile)
 		if err != nil {
 			utils.Log.Panicf("Running LoopFile failed: %v\n", err)
 		}
@@ -271,7 +338,7 @@ func RunLoopFile(data *hsdata.HsData, config *utils.Config) {
 		_, err = utils.LoopFile(data, utils.WriteLine, line)
 	}
 	if err != nil {
-		utils.Log.Panicf("Running LoopFile failed: %v\n", err)
+		utils.Log.Fatalf(1, "Running LoopFile failed: %v\n", err)
 	}
 }
 
@@ -295,43 +362,67 @@ func SkipSeperators(separator string) (string, int, int) {
 }
 
 func UseDefaults(data *hsdata.HsData, config *utils.Config) hsdata.FormattingData {
-	config_file, err := utils.GetDataPath("formats.json")
-	if err != nil {
-		utils.Log.Println("Please create the config directory ($XDG_CONFIG_HOME/histgrep/ or $HOME/.histgrep/)")
-		os.Exit(1)
+	formatMap, err := utils.LoadFormats(config)
+	name := DefaultFormatName(config)
+	if err := utils.FormatLoadError(err, name); err != nil {
+		utils.Log.Fatalf(1, "Loading formats failed: %v\n", err)
+	}
+	format_data, ok := formatMap[name]
+	if !ok {
+		utils.Log.Fatalf(1, "Default format not found: %v (set search.default_name in histgrep.toml)\n", name)
+	}
+	return format_data
+}
+
+// The format used without -n: search.default_name from histgrep.toml, or the
+// built-in default format.
+func DefaultFormatName(config *utils.Config) string {
+	if config == nil || config.Search.DefaultName == "" || config.Search.DefaultName == "EMPTY" {
+		return utils.DefaultFormatName
+	}
+	return config.Search.DefaultName
+}
+
+// Print any mistakes in the format being used. The search still runs, since
+// most mistakes only lose colours or fields.
+func warnFormatProblems(name string, format_data *hsdata.FormattingData) {
+	for _, problem := range utils.ValidateFormat(format_data) {
+		utils.Log.Fprintf(os.Stderr, "Warning: format %v: %v\n", name, problem)
 	}
-	utils.Log.Infof("Using config file %v\n", config_file)
-	formatMap := hsdata.FormatMap{}
-	utils.FetchFormatting(config_file, &formatMap)
-	return formatMap.Get(config.Search.DefaultName) // This can fail and I should return an error instead.
 }
 
 func DoConfigFile(data *hsdata.HsData) *utils.Config {
 	utils.Log.Debugf("Starting config file processing\n")
 
-	var config *utils.Config
 	file, err := utils.GetDataPath("histgrep.toml")
 	if err != nil {
-		utils.Log.Debugf("No config file found, returning nil config: %v\n", err)
-		return config
+		utils.Log.Debugf("No config file found, using the defaults and environment: %v\n", err)
+		file = ""
+	} else {
+		utils.Log.Debugf("Found config file: %s\n", file)
 	}
-	utils.Log.Debugf("Found config file: %s\n", file)
 
-	config, err = utils.LoadConfig(file)
+	config, err := utils.LoadConfig(file)
 	if err != nil {
-		utils.Log.Debugf("Failed to load config, returning nil: %v\n", err)
-		return config
+		if file == "" {
+			file = "the HISTGREP_ settings in the environment"
+		}
+		utils.Log.Fprintf(os.Stderr, "Warning: ignoring %v: %v\n", file, err)
+		return nil
 	}
 	utils.Log.Debugf("Config loaded successfully\n")
 
 	data.CaseSensitive = config.Search.CaseSensitive
+	data.Jobs = config.Search.Jobs
 	data.UsePager = config.Display.PagerEnabled
 	data.NoColor = !config.Display.ColorEnabled
 	utils.Log.Tracef("Data updated - CaseSensitive: %t, UsePager: %t, NoColor: %t\n",
 		data.CaseSensitive, data.UsePager, data.NoColor)
 
 	utils.Log.Debugf("Current Input_file: %s\n", data.InputFile)
-	if data.InputFile == "stdin" {
+	// Without a histgrep.toml the default logs are only searched when the
+	// environment sets a file pattern.
+	if data.InputFile == "stdin" && (config.Path() != "" || config.DefaultLogs.FilePattern != "EMPTY") {
 		utils.Log.Debugf("Processing stdin input\n")
 		stat, _ := os.Stdin.Stat()
 		utils.Log.Tracef("Stdin mode: %v, is char device: %t\n", stat.Mode(), (stat.Mode()&os.ModeCharDevice) != 0)
@@ -348,9 +439,8 @@ func DoConfigFile(data *hsdata.HsData) *utils.Config {
 			data.InputFile = "default_files"
 		} else {
 			utils.Log.Debugf("Stdin is not terminal, checking if empty\n")
-			// Read a bit from stdin to check if it's empty
-			buf := make([]byte, 1)
-			_, err := os.Stdin.Read(buf)
+			// Peek at stdin to check if it's empty without consuming anything
+			_, err := utils.Stdin.Peek(1)
 			if err == io.EOF {
 				utils.Log.Debugf("Stdin is empty, getting default log files\n")
 				// Get matching log files
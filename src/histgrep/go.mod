@@ -8,9 +8,11 @@ require (
 	github.com/charmbracelet/bubbles v0.20.0
 	github.com/charmbracelet/bubbletea v1.1.1
 	github.com/charmbracelet/lipgloss v0.13.0
+	github.com/klauspost/compress v1.17.9
 	github.com/muesli/termenv v0.15.2
 	github.com/sirupsen/logrus v1.9.3
 	github.com/spf13/cobra v1.8.1
+	github.com/ulikunitz/xz v0.5.12
 )
 
 require (
@@ -4,10 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"time"
 )
 
 type HsLine struct {
 	Line     string
+	File     string
+	LineNo   int
 	F        *os.File
 	OutLines []string
 }
@@ -24,6 +27,16 @@ type HsData struct {
 	UsePager       bool
 	IncludeNumbers bool
 	CaseSensitive  bool
+	UseRegex       bool
+	Since          time.Time
+	Until          time.Time
+	TimeLayout     string
+	ContextBefore  int
+	ContextAfter   int
+	Jobs           int
+	WithFilename   bool
+	LineNumbers    bool
+	OutputFormat   string
 	Reader         interface{}
 }
 
@@ -32,10 +45,28 @@ type HsData struct {
 // type MapMap map[string]map[string]string
 
 type FormattingData struct {
-	Input    map[string][]string
-	Output   map[string][]string
-	Color    map[string]map[string]string
-	Excludes map[string]map[string][]string
+	Input      map[string][]string
+	Output     map[string][]string
+	Color      map[string]map[string]string
+	Excludes   map[string]map[string][]string
+	ColorRules []ColorRule `json:",omitempty"`
+	// The name of a format to start from. Sections set here override its
+	// entries key by key.
+	Extends string `json:"extends,omitempty" toml:"extends"`
+}
+
+// A conditional colour for one key. Set one of the match fields; rules are
+// tried from the highest priority down (in the order listed for equal
+// priorities) and the first match wins over the Color section.
+type ColorRule struct {
+	Key        string `json:"key" toml:"key"`
+	Contains   string `json:"contains,omitempty" toml:"contains"`
+	StartsWith string `json:"starts_with,omitempty" toml:"starts_with"`
+	EndsWith   string `json:"ends_with,omitempty" toml:"ends_with"`
+	Equals     string `json:"equals,omitempty" toml:"equals"`
+	Regex      string `json:"regex,omitempty" toml:"regex"`
+	Color      string `json:"color" toml:"color"`
+	Priority   int    `json:"priority,omitempty" toml:"priority"`
 }
 
 type FormatMap map[string]FormattingData
@@ -167,3 +198,5 @@ const ColorGreen = "\033[0;32m"
 const ColorBlue = "\033[0;34m"
 const ColorNone = "\033[0m"
 const ColorGrey = "\033[1;30m"
+const ColorContext = "\033[2m"
+const ColorMatch = "\033[1;7m"
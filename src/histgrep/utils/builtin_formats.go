@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"errors"
+
+	"github.com/TJN25/histgrep/hsdata"
+)
+
+// The format used when histgrep.toml does not set search.default_name. It
+// shows lines as they are.
+const DefaultFormatName = "default"
+
+// The format of the logs written by histgrep hook: one line per command with
+// tab separated fields.
+const RichFormatName = "rich"
+
+// The separator between the fields of the rich format.
+const RichSeparator = "\t"
+
+// Formats that are always available with -n NAME. Entries in formats.json
+// or histgrep.toml with the same name replace them.
+func BuiltinFormats() hsdata.FormatMap {
+	return hsdata.FormatMap{
+		DefaultFormatName: hsdata.FormattingData{
+			Input: map[string][]string{
+				"keys":       {"line"},
+				"separators": {},
+			},
+			Output: map[string][]string{
+				"keys":       {"BLANK"},
+				"separators": {},
+			},
+		},
+		InputTypeZsh: hsdata.FormattingData{
+			Input: map[string][]string{
+				"type": {InputTypeZsh},
+			},
+			Output: map[string][]string{
+				"keys":       {"command"},
+				"separators": {},
+			},
+			Color: map[string]map[string]string{
+				"command": {"default": "green"},
+			},
+		},
+		InputTypeBash: hsdata.FormattingData{
+			Input: map[string][]string{
+				"type": {InputTypeBash},
+			},
+			Output: map[string][]string{
+				"keys":       {"command"},
+				"separators": {},
+			},
+			Color: map[string]map[string]string{
+				"command": {"default": "green"},
+			},
+		},
+		InputTypeFish: hsdata.FormattingData{
+			Input: map[string][]string{
+				"type": {InputTypeFish},
+			},
+			Output: map[string][]string{
+				"keys":       {"command"},
+				"separators": {},
+			},
+			Color: map[string]map[string]string{
+				"command": {"default": "green"},
+			},
+		},
+		RichFormatName: hsdata.FormattingData{
+			Input: map[string][]string{
+				"type": {InputTypeRich},
+			},
+			Output: map[string][]string{
+				"keys":       {"command", "directory", "status", "duration"},
+				"separators": {" # ", " [", " ", "s]"},
+			},
+			Color: map[string]map[string]string{
+				"command":   {"default": "green"},
+				"directory": {"default": "grey"},
+				"status":    {"default": "red", "=0": "grey"},
+				"duration":  {"default": "grey"},
+				"SEPARATOR": {"default": "grey"},
+			},
+		},
+	}
+}
+
+// Load the built-in formats, then any formats from formats.json and the
+// [formats] tables of histgrep.toml (config may be nil), with "extends"
+// resolved. Formats that cannot be resolved are reported as FormatErrors
+// alongside the others (see FormatLoadError).
+func LoadFormats(config *Config) (hsdata.FormatMap, error) {
+	formatMap := BuiltinFormats()
+	file, err := GetDataPath("formats.json")
+	if err != nil {
+		Log.Infof("No formats.json found\n")
+	} else {
+		userFormats := hsdata.FormatMap{}
+		if err := FetchFormatting(file, &userFormats); err != nil {
+			return formatMap, err
+		}
+		for name, format := range userFormats {
+			formatMap[name] = format
+		}
+	}
+	if config != nil {
+		for name, format := range config.Formats {
+			formatMap[name] = format
+		}
+	}
+	return ResolveFormats(formatMap)
+}
+
+// The error from LoadFormats that stops a format being used: the reason it
+// could not be resolved, nil if only other formats are broken, or any other
+// error as it is.
+func FormatLoadError(err error, name string) error {
+	var broken FormatErrors
+	if errors.As(err, &broken) {
+		return broken[name]
+	}
+	return err
+}
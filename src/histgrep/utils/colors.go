@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/TJN25/histgrep/hsdata"
+	"github.com/muesli/termenv"
+)
+
+// The escape codes of the colours histgrep has always supported, kept as they
+// were so existing formats look the same.
+var legacyColors = map[string]string{
+	"red":   hsdata.ColorRed,
+	"green": hsdata.ColorGreen,
+	"blue":  hsdata.ColorBlue,
+	"grey":  hsdata.ColorGrey,
+}
+
+var namedColors = map[string]termenv.ANSIColor{
+	"black":         termenv.ANSIBlack,
+	"red":           termenv.ANSIRed,
+	"green":         termenv.ANSIGreen,
+	"yellow":        termenv.ANSIYellow,
+	"blue":          termenv.ANSIBlue,
+	"magenta":       termenv.ANSIMagenta,
+	"cyan":          termenv.ANSICyan,
+	"white":         termenv.ANSIWhite,
+	"grey":          termenv.ANSIBrightBlack,
+	"gray":          termenv.ANSIBrightBlack,
+	"brightblack":   termenv.ANSIBrightBlack,
+	"brightred":     termenv.ANSIBrightRed,
+	"brightgreen":   termenv.ANSIBrightGreen,
+	"brightyellow":  termenv.ANSIBrightYellow,
+	"brightblue":    termenv.ANSIBrightBlue,
+	"brightmagenta": termenv.ANSIBrightMagenta,
+	"brightcyan":    termenv.ANSIBrightCyan,
+	"brightwhite":   termenv.ANSIBrightWhite,
+}
+
+var colorAttributes = map[string]string{
+	"bold":          termenv.BoldSeq,
+	"dim":           termenv.FaintSeq,
+	"faint":         termenv.FaintSeq,
+	"italic":        termenv.ItalicSeq,
+	"underline":     termenv.UnderlineSeq,
+	"blink":         termenv.BlinkSeq,
+	"reverse":       termenv.ReverseSeq,
+	"inverse":       termenv.ReverseSeq,
+	"strikethrough": termenv.CrossOutSeq,
+	"crossout":      termenv.CrossOutSeq,
+	"overline":      termenv.OverlineSeq,
+}
+
+var (
+	colorProfile     termenv.Profile
+	colorProfileOnce sync.Once
+	colorCache       sync.Map
+)
+
+// The colour profile of the terminal, from TERM and COLORTERM. Colours are
+// only turned on or off by the color settings, so the basic 16 colours are
+// always available and only 256 colour and hex values are downgraded.
+func terminalProfile() termenv.Profile {
+	colorProfileOnce.Do(func() {
+		colorProfile = termenv.NewOutput(os.Stdout, termenv.WithTTY(true)).ColorProfile()
+		if colorProfile > termenv.ANSI {
+			colorProfile = termenv.ANSI
+		}
+	})
+	return colorProfile
+}
+
+// The escape code for a colour from a format. Unknown colours are logged and
+// leave the text in the terminal's default colour.
+func InsertColor(color string) string {
+	if code, ok := colorCache.Load(color); ok {
+		return code.(string)
+	}
+	code, err := ParseColor(color)
+	if err != nil {
+		Log.Warnf("%v\n", err)
+		code = hsdata.ColorNone
+	}
+	colorCache.Store(color, code)
+	return code
+}
+
+// Parse a colour from a format into an escape code. A colour is a list of
+// words separated by spaces or commas: attributes (bold, dim, italic,
+// underline, blink, reverse, strikethrough, overline), a foreground colour and
+// a background colour after "on", e.g. "bold #ff8700 on 236". Colours are
+// names (red, bright_red, grey, ...), 256 colour indices or #rrggbb hex.
+func ParseColor(spec string) (string, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	if code, ok := legacyColors[spec]; ok {
+		return code, nil
+	}
+	var sequences []string
+	background := false
+	for _, word := range strings.FieldsFunc(spec, func(r rune) bool { return r == ' ' || r == ',' }) {
+		if word == "none" || word == "default" {
+			continue
+		}
+		if word == "on" {
+			background = true
+			continue
+		}
+		if attribute, ok := colorAttributes[word]; ok {
+			sequences = append(sequences, attribute)
+			continue
+		}
+		color, err := parseColorValue(word)
+		if err != nil {
+			return "", fmt.Errorf("invalid color %q: %v", spec, err)
+		}
+		sequences = append(sequences, terminalProfile().Convert(color).Sequence(background))
+		background = false
+	}
+	if background {
+		return "", fmt.Errorf("invalid color %q: missing color after \"on\"", spec)
+	}
+	if len(sequences) == 0 {
+		return hsdata.ColorNone, nil
+	}
+	return termenv.CSI + strings.Join(sequences, ";") + "m", nil
+}
+
+func parseColorValue(word string) (termenv.Color, error) {
+	if strings.HasPrefix(word, "#") {
+		if len(word) != 7 {
+			return nil, fmt.Errorf("%q is not a #rrggbb color", word)
+		}
+		if _, err := strconv.ParseUint(word[1:], 16, 32); err != nil {
+			return nil, fmt.Errorf("%q is not a #rrggbb color", word)
+		}
+		return termenv.RGBColor(word), nil
+	}
+	if index, err := strconv.Atoi(word); err == nil {
+		if index < 0 || index > 255 {
+			return nil, fmt.Errorf("color index %d is not between 0 and 255", index)
+		}
+		if index < 16 {
+			return termenv.ANSIColor(index), nil
+		}
+		return termenv.ANSI256Color(index), nil
+	}
+	name := strings.NewReplacer("_", "", "-", "").Replace(word)
+	if color, ok := namedColors[name]; ok {
+		return color, nil
+	}
+	return nil, fmt.Errorf("unknown color or attribute %q", word)
+}
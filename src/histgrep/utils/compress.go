@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Extensions of the compressed files histgrep can read.
+var CompressedExtensions = []string{".gz", ".zst", ".bz2", ".xz"}
+
+var compressionMagic = []struct {
+	name  string
+	magic []byte
+}{
+	{".gz", []byte{0x1f, 0x8b}},
+	{".zst", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{".bz2", []byte("BZh")},
+	{".xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+}
+
+type closeFunc func() error
+
+func (fn closeFunc) Close() error {
+	return fn()
+}
+
+// Work out how a stream is compressed from its first bytes, falling back to
+// the file extension. Returns "" for plain text.
+func detectCompression(header []byte, name string) string {
+	for _, format := range compressionMagic {
+		if !bytes.HasPrefix(header, format.magic) {
+			continue
+		}
+		// bzip2 streams go on with the block size, 1 to 9, so text that
+		// happens to start with BZh is not taken for one.
+		if format.name == ".bz2" && (len(header) < 4 || header[3] < '1' || header[3] > '9') {
+			continue
+		}
+		return format.name
+	}
+	if len(header) == 0 {
+		return ""
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, known := range CompressedExtensions {
+		if ext == known {
+			return ext
+		}
+	}
+	return ""
+}
+
+// Wrap a reader so that compressed input (gzip, zstd, bzip2 or xz) is
+// decompressed transparently. Plain text is returned unchanged. The returned
+// closer releases the decompressor, not the underlying reader.
+func decompressReader(r *bufio.Reader, name string) (*bufio.Reader, io.Closer, error) {
+	header, _ := r.Peek(6)
+	compression := detectCompression(header, name)
+	if compression != "" {
+		Log.Debugf("Reading %s as %s compressed\n", name, compression)
+	}
+	switch compression {
+	case ".gz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading gzip file %s: %v", name, err)
+		}
+		return bufio.NewReaderSize(gz, inputBufferSize), gz, nil
+	case ".zst":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading zstd file %s: %v", name, err)
+		}
+		return bufio.NewReaderSize(zr, inputBufferSize), closeFunc(func() error { zr.Close(); return nil }), nil
+	case ".bz2":
+		return bufio.NewReaderSize(bzip2.NewReader(r), inputBufferSize), nil, nil
+	case ".xz":
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading xz file %s: %v", name, err)
+		}
+		return bufio.NewReaderSize(xr, inputBufferSize), nil, nil
+	}
+	return r, nil, nil
+}
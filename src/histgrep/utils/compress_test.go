@@ -0,0 +1,22 @@
+package utils
+
+import "testing"
+
+func TestDetectCompressionBzip2(t *testing.T) {
+	tests := []struct {
+		header string
+		name   string
+		want   string
+	}{
+		{"BZh91AY&SY", "history.log", ".bz2"},
+		{"BZh1", "history.log", ".bz2"},
+		{"BZhello world", "history.log", ""},
+		{"BZh", "history.log", ""},
+		{"BZh0", "history.log", ""},
+	}
+	for _, tt := range tests {
+		if got := detectCompression([]byte(tt.header), tt.name); got != tt.want {
+			t.Errorf("detectCompression(%q, %q) = %q, want %q", tt.header, tt.name, got, tt.want)
+		}
+	}
+}
@@ -3,8 +3,11 @@ package utils
 import (
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/BurntSushi/toml"
+	"github.com/TJN25/histgrep/hsdata"
 )
 
 type Config struct {
@@ -15,47 +18,70 @@ type Config struct {
 	Search struct {
 		CaseSensitive bool   `toml:"case_sensitive"`
 		DefaultName   string `toml:"default_name"`
+		Jobs          int    `toml:"jobs"`
 	} `toml:"search"`
 	Display struct {
 		ColorEnabled bool `toml:"color_enabled"`
 		PagerEnabled bool `toml:"pager_enabled"`
 		VimExit      bool `toml:"vim_exit"`
 	} `toml:"display"`
+	// Rules for histgrep record: commands matching an ignore pattern are not
+	// logged, and the parts of a command matching a redact pattern (or its
+	// groups, if it has any) are replaced before it is written.
+	Record struct {
+		Ignore []string `toml:"ignore"`
+		Redact []string `toml:"redact"`
+	} `toml:"record"`
+	// Formats declared as [formats.NAME] tables. They replace formats of the
+	// same name from formats.json.
+	Formats map[string]hsdata.FormattingData `toml:"formats"`
+
+	// The file the config was read from, where each setting came from and
+	// any keys in the file that are not settings.
+	path      string
+	sources   map[string]string
+	undecoded []toml.Key
+}
+
+// The settings used when histgrep.toml does not set them.
+func DefaultConfig() *Config {
+	config := &Config{}
+	config.DefaultLogs.Directory = "~/.logs/"
+	config.DefaultLogs.FilePattern = "EMPTY"
+	config.Search.CaseSensitive = false
+	config.Search.DefaultName = "EMPTY"
+	config.Search.Jobs = 0
+	config.Display.ColorEnabled = true
+	config.Display.PagerEnabled = false
+	config.Display.VimExit = false
+	config.sources = make(map[string]string)
+	return config
 }
 
+// Load the config from a TOML file over the defaults, then apply any
+// HISTGREP_SECTION_KEY environment variables. An empty path loads only the
+// defaults and the environment.
 func LoadConfig(path string) (*Config, error) {
 	Log.Debugf("Loading configuration from: %s\n", path)
 
-	config := &Config{
-		DefaultLogs: struct {
-			Directory   string `toml:"directory"`
-			FilePattern string `toml:"file_pattern"`
-		}{
-			Directory:   "~/.logs/",
-			FilePattern: "EMPTY",
-		},
-		Search: struct {
-			CaseSensitive bool   `toml:"case_sensitive"`
-			DefaultName   string `toml:"default_name"`
-		}{
-			CaseSensitive: false,
-			DefaultName:   "EMPTY",
-		},
-		Display: struct {
-			ColorEnabled bool `toml:"color_enabled"`
-			PagerEnabled bool `toml:"pager_enabled"`
-			VimExit      bool `toml:"vim_exit"`
-		}{
-			ColorEnabled: true,
-			PagerEnabled: false,
-			VimExit:      false,
-		},
-	}
-
+	config := DefaultConfig()
 	Log.Tracef("Config defaults: %+v\n", config)
 
-	if _, err := toml.DecodeFile(path, config); err != nil {
-		Log.Debugf("Failed to decode TOML file: %v\n", err)
+	if path != "" {
+		meta, err := toml.DecodeFile(path, config)
+		if err != nil {
+			Log.Debugf("Failed to decode TOML file: %v\n", err)
+			return nil, err
+		}
+		config.path = path
+		config.undecoded = meta.Undecoded()
+		for _, key := range ConfigKeys() {
+			if meta.IsDefined(strings.Split(key, ".")...) {
+				config.sources[key] = SourceFile
+			}
+		}
+	}
+	if err := config.applyEnv(); err != nil {
 		return nil, err
 	}
 
@@ -75,8 +101,45 @@ func LoadConfig(path string) (*Config, error) {
 	return config, nil
 }
 
+// Load histgrep.toml from the config directory, or only the defaults and
+// environment if there is no histgrep.toml.
+func LoadConfigFile() (*Config, error) {
+	file, err := GetDataPath("histgrep.toml")
+	if err != nil {
+		file = ""
+	}
+	return LoadConfig(file)
+}
+
+// Find the default log files, including compressed copies (e.g. rotated
+// .log.gz files) of anything matching the file pattern, sorted by name.
+// Placeholders such as {SHELL} and {YYYY} in the pattern match any value.
+// A file matched by both the pattern and a compressed copy of it (e.g. a.log.gz
+// with the pattern *) is only listed once.
 func GetMatchingLogFiles(config *Config) ([]string, error) {
-	pattern := filepath.Join(config.DefaultLogs.Directory, config.DefaultLogs.FilePattern)
-	files, err := filepath.Glob(pattern)
-	return files, err
+	pattern := filepath.Join(config.DefaultLogs.Directory, FilePatternGlob(config.DefaultLogs.FilePattern))
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return matches, err
+	}
+	for _, ext := range CompressedExtensions {
+		if strings.HasSuffix(pattern, ext) {
+			continue
+		}
+		compressed, err := filepath.Glob(pattern + ext)
+		if err != nil {
+			return matches, err
+		}
+		matches = append(matches, compressed...)
+	}
+	seen := make(map[string]bool)
+	files := make([]string, 0, len(matches))
+	for _, file := range matches {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
 }
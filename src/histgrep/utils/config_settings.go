@@ -0,0 +1,276 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Where the value of a setting came from, from lowest to highest precedence.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// The value of one setting and where it came from.
+type Setting struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+// The names of the settings in histgrep.toml, as section.key, in the order
+// they are declared in Config.
+func ConfigKeys() []string {
+	var keys []string
+	configType := reflect.TypeOf(Config{})
+	for i := 0; i < configType.NumField(); i++ {
+		section := configType.Field(i)
+		sectionName := section.Tag.Get("toml")
+		if section.Type.Kind() != reflect.Struct || sectionName == "" {
+			continue
+		}
+		for j := 0; j < section.Type.NumField(); j++ {
+			keys = append(keys, sectionName+"."+section.Type.Field(j).Tag.Get("toml"))
+		}
+	}
+	return keys
+}
+
+// The environment variable that overrides a setting, e.g. HISTGREP_SEARCH_JOBS
+// for search.jobs.
+func ConfigEnvName(key string) string {
+	return "HISTGREP_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// The struct field holding a setting.
+func (c *Config) field(key string) (reflect.Value, error) {
+	sectionName, fieldName, ok := strings.Cut(key, ".")
+	if ok {
+		configValue := reflect.ValueOf(c).Elem()
+		for i := 0; i < configValue.NumField(); i++ {
+			if configValue.Type().Field(i).Tag.Get("toml") != sectionName {
+				continue
+			}
+			section := configValue.Field(i)
+			if section.Kind() != reflect.Struct {
+				break
+			}
+			for j := 0; j < section.NumField(); j++ {
+				if section.Type().Field(j).Tag.Get("toml") == fieldName {
+					return section.Field(j), nil
+				}
+			}
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("unknown setting %q (available settings: %s)", key, strings.Join(ConfigKeys(), ", "))
+}
+
+// The value of a setting as text.
+func (c *Config) Get(key string) (string, error) {
+	value, err := c.field(key)
+	if err != nil {
+		return "", err
+	}
+	if value.Kind() == reflect.Slice {
+		// The encoder leaves out nil slices.
+		if value.Len() == 0 {
+			return "[]", nil
+		}
+		var encoded bytes.Buffer
+		if err := toml.NewEncoder(&encoded).Encode(map[string]interface{}{"v": value.Interface()}); err != nil {
+			return "", err
+		}
+		return strings.TrimPrefix(strings.TrimSpace(encoded.String()), "v = "), nil
+	}
+	return fmt.Sprint(value.Interface()), nil
+}
+
+// Change a setting from text and record where the new value came from.
+func (c *Config) Set(key string, text string, source string) error {
+	value, err := c.field(key)
+	if err != nil {
+		return err
+	}
+	switch value.Kind() {
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("%s must be true or false, not %q", key, text)
+		}
+		value.SetBool(parsed)
+	case reflect.Int:
+		parsed, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("%s must be a whole number, not %q", key, text)
+		}
+		value.SetInt(int64(parsed))
+	case reflect.Slice:
+		var parsed struct{ V []string }
+		if _, err := toml.Decode("v = "+text, &parsed); err != nil {
+			return fmt.Errorf("%s must be a list of strings such as [\"a\", \"b\"], not %q", key, text)
+		}
+		value.Set(reflect.ValueOf(parsed.V))
+	default:
+		value.SetString(text)
+	}
+	if c.sources == nil {
+		c.sources = make(map[string]string)
+	}
+	c.sources[key] = source
+	return nil
+}
+
+// Where a setting came from: default, file, env or flag.
+func (c *Config) Source(key string) string {
+	if source, ok := c.sources[key]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+// The file the config was loaded from, or "" if there is none.
+func (c *Config) Path() string {
+	return c.path
+}
+
+// Keys in the config file that histgrep does not know about.
+func (c *Config) UnknownKeys() []string {
+	var keys []string
+	for _, key := range c.undecoded {
+		keys = append(keys, key.String())
+	}
+	return keys
+}
+
+// Every setting with its value and source.
+func (c *Config) Settings() []Setting {
+	var settings []Setting
+	for _, key := range ConfigKeys() {
+		value, _ := c.Get(key)
+		settings = append(settings, Setting{Key: key, Value: value, Source: c.Source(key)})
+	}
+	return settings
+}
+
+func (c *Config) applyEnv() error {
+	for _, key := range ConfigKeys() {
+		text, ok := os.LookupEnv(ConfigEnvName(key))
+		if !ok {
+			continue
+		}
+		if err := c.Set(key, text, SourceEnv); err != nil {
+			return fmt.Errorf("%s: %v", ConfigEnvName(key), err)
+		}
+	}
+	return nil
+}
+
+var tomlSection = regexp.MustCompile(`^\s*\[\s*([^\]]*?)\s*\]`)
+
+// Set a value in a histgrep.toml file, keeping the rest of the file (and its
+// comments) as it is. The file is created if needed, and replaced in one step
+// only once the new contents have been checked.
+func WriteConfigValue(path string, key string, text string) error {
+	config := DefaultConfig()
+	if err := config.Set(key, text, SourceFile); err != nil {
+		return err
+	}
+	value, _ := config.field(key)
+	sectionName, fieldName, _ := strings.Cut(key, ".")
+	var encoded bytes.Buffer
+	if err := toml.NewEncoder(&encoded).Encode(map[string]interface{}{fieldName: value.Interface()}); err != nil {
+		return err
+	}
+	newLine := strings.TrimSpace(encoded.String())
+
+	var lines []string
+	contents, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(contents) > 0 {
+		lines = strings.Split(strings.TrimRight(string(contents), "\n"), "\n")
+	}
+	lines = setTomlLine(lines, sectionName, fieldName, newLine)
+	updated := []byte(strings.Join(lines, "\n") + "\n")
+	if _, err := toml.Decode(string(updated), DefaultConfig()); err != nil {
+		return fmt.Errorf("not writing %v, the result would not be valid: %v", path, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".histgrep.toml.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(updated); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Replace the line setting fieldName in [sectionName], or add it at the end
+// of the section (adding the section if it is missing).
+func setTomlLine(lines []string, sectionName string, fieldName string, newLine string) []string {
+	keyLine := regexp.MustCompile(`^\s*"?` + regexp.QuoteMeta(fieldName) + `"?\s*=`)
+	current := ""
+	insertAt := -1
+	for i, line := range lines {
+		if groups := tomlSection.FindStringSubmatch(line); groups != nil {
+			current = groups[1]
+			continue
+		}
+		if current != sectionName {
+			continue
+		}
+		if keyLine.MatchString(line) {
+			lines[i] = newLine
+			return lines
+		}
+		if strings.TrimSpace(line) != "" {
+			insertAt = i + 1
+		}
+	}
+	if insertAt < 0 {
+		for i, line := range lines {
+			if groups := tomlSection.FindStringSubmatch(line); groups != nil && groups[1] == sectionName {
+				insertAt = i + 1
+			}
+		}
+	}
+	if insertAt < 0 {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		return append(lines, "["+sectionName+"]", newLine)
+	}
+	lines = append(lines[:insertAt], append([]string{newLine}, lines[insertAt:]...)...)
+	return lines
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGetMatchingLogFilesCompressed(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.log", "a.log.gz", "b.log.gz", "c.log.zst", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"*", []string{"a.log", "a.log.gz", "b.log.gz", "c.log.zst", "notes.txt"}},
+		{"*.log", []string{"a.log", "a.log.gz", "b.log.gz", "c.log.zst"}},
+		{"b*", []string{"b.log.gz"}},
+		{"*.gz", []string{"a.log.gz", "b.log.gz"}},
+	}
+	for _, tt := range tests {
+		config := &Config{}
+		config.DefaultLogs.Directory = dir
+		config.DefaultLogs.FilePattern = tt.pattern
+		files, err := GetMatchingLogFiles(config)
+		if err != nil {
+			t.Fatalf("GetMatchingLogFiles(%q) failed: %v", tt.pattern, err)
+		}
+		want := make([]string, 0, len(tt.want))
+		for _, name := range tt.want {
+			want = append(want, filepath.Join(dir, name))
+		}
+		if !reflect.DeepEqual(files, want) {
+			t.Errorf("GetMatchingLogFiles(%q) = %v, want %v", tt.pattern, files, want)
+		}
+	}
+}
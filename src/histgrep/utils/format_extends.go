@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/TJN25/histgrep/hsdata"
+)
+
+// Formats whose "extends" could not be resolved, with the reason for each.
+type FormatErrors map[string]error
+
+func (e FormatErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, name := range sortedKeys(e) {
+		messages = append(messages, e[name].Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Resolve "extends" in every format. A format starts from the resolved format
+// it extends and each key it sets in Input, Output, Color and Excludes
+// replaces the inherited one. Its ColorRules are tried before the inherited
+// rules of the same priority. Formats that cannot be resolved are left out
+// and returned as FormatErrors, so the rest can still be used.
+func ResolveFormats(formatMap hsdata.FormatMap) (hsdata.FormatMap, error) {
+	resolved := hsdata.FormatMap{}
+	broken := FormatErrors{}
+	for _, name := range sortedKeys(formatMap) {
+		if _, err := resolveFormat(name, formatMap, resolved, nil); err != nil {
+			broken[name] = err
+		}
+	}
+	if len(broken) > 0 {
+		return resolved, broken
+	}
+	return resolved, nil
+}
+
+func resolveFormat(name string, formatMap hsdata.FormatMap, resolved hsdata.FormatMap, chain []string) (hsdata.FormattingData, error) {
+	if format_data, ok := resolved[name]; ok {
+		return format_data, nil
+	}
+	for i, parent := range chain {
+		if parent == name {
+			return hsdata.FormattingData{}, fmt.Errorf("format inheritance cycle: %s", strings.Join(append(chain[i:], name), " -> "))
+		}
+	}
+	format_data, ok := formatMap[name]
+	if !ok {
+		return hsdata.FormattingData{}, fmt.Errorf("format %q extends unknown format %q", chain[len(chain)-1], name)
+	}
+	if format_data.Extends != "" {
+		parent, err := resolveFormat(format_data.Extends, formatMap, resolved, append(chain, name))
+		if err != nil {
+			return hsdata.FormattingData{}, err
+		}
+		format_data = mergeFormats(parent, format_data)
+	}
+	resolved[name] = format_data
+	return format_data, nil
+}
+
+func mergeFormats(parent hsdata.FormattingData, child hsdata.FormattingData) hsdata.FormattingData {
+	return hsdata.FormattingData{
+		Input:      mergeSection(parent.Input, child.Input),
+		Output:     mergeSection(parent.Output, child.Output),
+		Color:      mergeSection(parent.Color, child.Color),
+		Excludes:   mergeSection(parent.Excludes, child.Excludes),
+		ColorRules: append(append([]hsdata.ColorRule{}, child.ColorRules...), parent.ColorRules...),
+	}
+}
+
+func mergeSection[V any](parent map[string]V, child map[string]V) map[string]V {
+	if parent == nil && child == nil {
+		return nil
+	}
+	merged := make(map[string]V, len(parent)+len(child))
+	for key, value := range parent {
+		merged[key] = value
+	}
+	for key, value := range child {
+		merged[key] = value
+	}
+	return merged
+}
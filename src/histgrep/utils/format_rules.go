@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/TJN25/histgrep/hsdata"
+)
+
+// Conditions used by Excludes and ColorRules, checked in this order.
+const (
+	ConditionStartsWith = "starts_with"
+	ConditionContains   = "contains"
+	ConditionEndsWith   = "ends_with"
+	ConditionEquals     = "equals"
+	ConditionRegex      = "regex"
+)
+
+var Conditions = []string{ConditionStartsWith, ConditionContains, ConditionEndsWith, ConditionEquals, ConditionRegex}
+
+var formatPatterns sync.Map
+
+type compiledPattern struct {
+	pattern *regexp.Regexp
+	err     error
+}
+
+// Compile a regular expression from a format once and share it between lines
+// (and workers, when files are searched in parallel).
+func compileFormatPattern(expr string) (*regexp.Regexp, error) {
+	if cached, ok := formatPatterns.Load(expr); ok {
+		compiled := cached.(compiledPattern)
+		return compiled.pattern, compiled.err
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		err = fmt.Errorf("invalid regex %q: %v", expr, err)
+		Log.Warnf("%v\n", err)
+	}
+	formatPatterns.Store(expr, compiledPattern{pattern: pattern, err: err})
+	return pattern, err
+}
+
+// Whether text meets a condition. Invalid regular expressions never match.
+func matchCondition(condition string, value string, text string) bool {
+	switch condition {
+	case ConditionStartsWith:
+		return strings.HasPrefix(text, value)
+	case ConditionContains:
+		return strings.Contains(text, value)
+	case ConditionEndsWith:
+		return strings.HasSuffix(text, value)
+	case ConditionEquals:
+		return text == value
+	case ConditionRegex:
+		pattern, err := compileFormatPattern(value)
+		return err == nil && pattern.MatchString(text)
+	}
+	return false
+}
+
+// The condition and value a colour rule tests, or "" if it sets none.
+func ruleCondition(rule hsdata.ColorRule) (string, string) {
+	switch {
+	case rule.StartsWith != "":
+		return ConditionStartsWith, rule.StartsWith
+	case rule.Contains != "":
+		return ConditionContains, rule.Contains
+	case rule.EndsWith != "":
+		return ConditionEndsWith, rule.EndsWith
+	case rule.Equals != "":
+		return ConditionEquals, rule.Equals
+	case rule.Regex != "":
+		return ConditionRegex, rule.Regex
+	}
+	return "", ""
+}
+
+// Pick the colour of a key's value. ColorRules are tried first, by priority,
+// then the conditionals in the Color section and finally its default.
+//
+// Color conditionals are substrings of the value, or regular expressions when
+// written re:PATTERN and exact values when written =VALUE. When several match,
+// the longest conditional wins (ties go to the first in alphabetical order).
+func keyColorFor(key string, value string, format_data *hsdata.FormattingData) string {
+	var rules []hsdata.ColorRule
+	for _, rule := range format_data.ColorRules {
+		if rule.Key == key {
+			rules = append(rules, rule)
+		}
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Priority > rules[j].Priority })
+	for _, rule := range rules {
+		condition, expected := ruleCondition(rule)
+		if condition != "" && matchCondition(condition, expected, value) {
+			return rule.Color
+		}
+	}
+
+	color_map, ok := format_data.Color[key]
+	if !ok {
+		return ""
+	}
+	conditionals := make([]string, 0, len(color_map))
+	for conditional := range color_map {
+		if conditional != "default" {
+			conditionals = append(conditionals, conditional)
+		}
+	}
+	sort.Slice(conditionals, func(i, j int) bool {
+		if len(conditionals[i]) != len(conditionals[j]) {
+			return len(conditionals[i]) > len(conditionals[j])
+		}
+		return conditionals[i] < conditionals[j]
+	})
+	for _, conditional := range conditionals {
+		if colorConditionMatches(conditional, value) {
+			return color_map[conditional]
+		}
+	}
+	return color_map["default"]
+}
+
+func colorConditionMatches(conditional string, value string) bool {
+	if strings.HasPrefix(conditional, RegexPrefix) {
+		return matchCondition(ConditionRegex, strings.TrimPrefix(conditional, RegexPrefix), value)
+	}
+	if strings.HasPrefix(conditional, "=") {
+		return matchCondition(ConditionEquals, strings.TrimPrefix(conditional, "="), value)
+	}
+	return matchCondition(ConditionContains, conditional, value)
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/TJN25/histgrep/hsdata"
+)
+
+// Marks the text matched by the positive terms of a query (terms that are not
+// under NOT or -) so it is clear why a line matched.
+type highlighter struct {
+	// Terms without a field prefix, tried on every field (or only the
+	// search key of shell history formats) and on unformatted lines.
+	unscoped  []*regexp.Regexp
+	fields    map[string][]*regexp.Regexp
+	searchKey string
+}
+
+// A highlighter for a compiled query, or nil if there is nothing to highlight.
+func newHighlighter(query matcher, searchKey string, caseSensitive bool) *highlighter {
+	if query == nil {
+		return nil
+	}
+	h := &highlighter{fields: make(map[string][]*regexp.Regexp), searchKey: searchKey}
+	for _, term := range query.positiveTerms() {
+		pattern := term.highlightPattern(caseSensitive)
+		if term.field != "" {
+			h.fields[term.field] = append(h.fields[term.field], pattern)
+		} else {
+			h.unscoped = append(h.unscoped, pattern)
+		}
+	}
+	if len(h.unscoped) == 0 && len(h.fields) == 0 {
+		return nil
+	}
+	return h
+}
+
+// A pattern finding the text a term matched. Plain terms are quoted, and
+// ^term and term$ only match at the ends of a field.
+func (t *searchTerm) highlightPattern(caseSensitive bool) *regexp.Regexp {
+	if t.conditional == "Regex" {
+		return t.pattern
+	}
+	expr := regexp.QuoteMeta(t.term)
+	switch t.conditional {
+	case "StartsWith":
+		expr = "^" + expr
+	case "EndsWith":
+		expr = expr + "$"
+	}
+	if !caseSensitive {
+		expr = "(?i)" + expr
+	}
+	return regexp.MustCompile(expr)
+}
+
+func (h *highlighter) patternsFor(key string) []*regexp.Regexp {
+	if h == nil {
+		return nil
+	}
+	patterns := h.fields[key]
+	if h.searchKey == "" || h.searchKey == key {
+		patterns = append(patterns[:len(patterns):len(patterns)], h.unscoped...)
+	}
+	return patterns
+}
+
+func (h *highlighter) linePatterns() []*regexp.Regexp {
+	if h == nil {
+		return nil
+	}
+	return h.unscoped
+}
+
+// Wrap every match of the patterns in the match colour. The text around the
+// matches is assumed to be drawn in restore, which is switched back on after
+// each match.
+func highlightText(text string, patterns []*regexp.Regexp, match string, restore string) string {
+	var spans [][]int
+	for _, pattern := range patterns {
+		for _, span := range pattern.FindAllStringIndex(text, -1) {
+			if span[1] > span[0] {
+				spans = append(spans, span)
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return text
+	}
+	// Merge overlapping and adjacent matches so each run is coloured once.
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+	merged := [][]int{spans[0]}
+	for _, span := range spans[1:] {
+		current := merged[len(merged)-1]
+		if span[0] <= current[1] {
+			if span[1] > current[1] {
+				current[1] = span[1]
+			}
+			continue
+		}
+		merged = append(merged, span)
+	}
+	var out strings.Builder
+	last := 0
+	for _, span := range merged {
+		out.WriteString(text[last:span[0]])
+		out.WriteString(match + text[span[0]:span[1]] + hsdata.ColorNone + restore)
+		last = span[1]
+	}
+	out.WriteString(text[last:])
+	return out.String()
+}
+
+// The colour of highlighted matches: MATCH in the format's Color section, or
+// bold reverse video.
+func matchColor(format_data *hsdata.FormattingData) string {
+	if color_map, ok := format_data.Color["MATCH"]; ok {
+		return InsertColor(color_map["default"])
+	}
+	return hsdata.ColorMatch
+}
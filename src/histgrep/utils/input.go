@@ -0,0 +1,181 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Shared reader for stdin so that checking whether stdin is empty (with Peek)
+// does not lose any input.
+var Stdin = bufio.NewReader(os.Stdin)
+
+// Name used in StreamInput sources for standard input.
+const StdinSource = "-"
+
+// Size of the read buffer for each input. Lines longer than this are still
+// read in full, they just take more than one read.
+const inputBufferSize = 256 * 1024
+
+// StreamInput reads lines one at a time from a list of files (or stdin),
+// opening each file only when the previous one is finished, so memory use does
+// not grow with the size of the history.
+//
+// A rewindable StreamInput can be Reset and read again, which the pager needs
+// to re-run searches. Files are simply re-opened; stdin is copied to a
+// temporary spool file as it is read so it can be replayed from disk.
+type StreamInput struct {
+	sources    []string
+	index      int
+	reader     *bufio.Reader
+	closer     io.Closer
+	rewindable bool
+	spool      *os.File
+	spoolPath  string
+	spoolDone  bool
+	lineNo     int
+}
+
+func NewStreamInput(sources []string, rewindable bool) *StreamInput {
+	return &StreamInput{sources: sources, rewindable: rewindable}
+}
+
+// Read the next line without its line ending. Returns io.EOF once every source
+// has been read.
+func (si *StreamInput) ReadLine() (string, error) {
+	for {
+		if si.reader == nil {
+			if si.index >= len(si.sources) {
+				return "", io.EOF
+			}
+			if err := si.open(si.sources[si.index]); err != nil {
+				si.index++
+				return "", err
+			}
+		}
+		line, err := si.reader.ReadString('\n')
+		if si.spool != nil && line != "" {
+			if _, werr := si.spool.WriteString(line); werr != nil {
+				return "", fmt.Errorf("error buffering stdin: %v", werr)
+			}
+		}
+		if err == io.EOF && line == "" {
+			if si.sources[si.index] == StdinSource {
+				si.finishSpool()
+			}
+			si.closeCurrent()
+			si.index++
+			continue
+		}
+		if err != nil && err != io.EOF {
+			si.closeCurrent()
+			si.index++
+			return "", err
+		}
+		si.lineNo++
+		line = strings.TrimSuffix(line, "\n")
+		line = strings.TrimSuffix(line, "\r")
+		return line, nil
+	}
+}
+
+// The index of the source the last line was read from.
+func (si *StreamInput) Source() int {
+	return si.index
+}
+
+// The name of the source the last line was read from.
+func (si *StreamInput) SourceName() string {
+	if si.index >= len(si.sources) {
+		return ""
+	}
+	if si.sources[si.index] == StdinSource {
+		return "(standard input)"
+	}
+	return si.sources[si.index]
+}
+
+// The line number of the last line read, counted from 1 in each source.
+func (si *StreamInput) LineNumber() int {
+	return si.lineNo
+}
+
+// Start reading from the first source again.
+func (si *StreamInput) Reset() {
+	si.closeCurrent()
+	si.index = 0
+}
+
+// Close the current source and remove any stdin spool file.
+func (si *StreamInput) Close() error {
+	si.closeCurrent()
+	if si.spool != nil {
+		si.spool.Close()
+		si.spool = nil
+	}
+	if si.spoolPath != "" {
+		return os.Remove(si.spoolPath)
+	}
+	return nil
+}
+
+func (si *StreamInput) open(source string) error {
+	if source == StdinSource {
+		if si.spoolDone {
+			return si.openFile(si.spoolPath)
+		}
+		if si.rewindable && si.spool == nil {
+			spool, err := os.CreateTemp("", "histgrep-stdin-*")
+			if err != nil {
+				return fmt.Errorf("error buffering stdin: %v", err)
+			}
+			si.spool, si.spoolPath = spool, spool.Name()
+		}
+		// The spool holds the decompressed text, so it is replayed as is.
+		reader, decompressor, err := decompressReader(Stdin, "stdin")
+		if err != nil {
+			return err
+		}
+		si.reader, si.closer = reader, decompressor
+		return nil
+	}
+	return si.openFile(source)
+}
+
+func (si *StreamInput) openFile(source string) error {
+	file, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("error reading file %s: %v", source, err)
+	}
+	reader, decompressor, err := decompressReader(bufio.NewReaderSize(file, inputBufferSize), source)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	si.reader = reader
+	si.closer = closeFunc(func() error {
+		if decompressor != nil {
+			decompressor.Close()
+		}
+		return file.Close()
+	})
+	return nil
+}
+
+func (si *StreamInput) closeCurrent() {
+	if si.closer != nil {
+		si.closer.Close()
+	}
+	si.reader, si.closer = nil, nil
+	si.lineNo = 0
+}
+
+func (si *StreamInput) finishSpool() {
+	if si.spool != nil {
+		si.spool.Close()
+		si.spool = nil
+		si.spoolDone = true
+	}
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/TJN25/histgrep/hsdata"
+)
+
+// Input type for JSON Lines logs. Input.keys are paths into each object, such
+// as ts, ctx.cwd or args.0, and each value is stored under its path.
+const InputTypeJSON = "json"
+
+// Decode one JSON object per line. The second result is false for lines that
+// are not JSON objects, which follow Input.on_mismatch like pattern formats.
+func parseJSONLine(line string, format_data *hsdata.FormattingData) (MapFormat, bool) {
+	words := make(MapFormat)
+	var object map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil || object == nil {
+		if getMismatchPolicy(format_data) == MismatchFallback {
+			words[getFallbackKey(format_data)] = line
+		}
+		return words, false
+	}
+	for _, key := range format_data.Input["keys"] {
+		value, ok := lookupJSONPath(object, key)
+		if ok {
+			words[key] = jsonValueString(value)
+		} else {
+			words[key] = ""
+		}
+	}
+	return words, true
+}
+
+// Follow a dotted path through nested objects and arrays.
+func lookupJSONPath(object map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = object
+	for _, part := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			next, ok := node[part]
+			if !ok {
+				return nil, false
+			}
+			current = next
+		case []interface{}:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			current = node[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// Strings are used as they are, null is empty and anything else is written
+// back out as compact JSON.
+func jsonValueString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
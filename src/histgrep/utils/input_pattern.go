@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/TJN25/histgrep/hsdata"
+)
+
+// Input type for formats that set Input.pattern, a regular expression whose
+// named groups ((?P<date>\S+) (?P<command>.*)) become the keys of each line.
+const InputTypePattern = "pattern"
+
+// Policies for lines that do not match Input.pattern, set with
+// Input.on_mismatch.
+const (
+	// Drop the line.
+	MismatchSkip = "skip"
+	// Show the line as it is, without formatting.
+	MismatchRaw = "raw"
+	// Put the whole line in Input.fallback_key (default "command").
+	MismatchFallback = "fallback"
+)
+
+var inputPatterns sync.Map
+
+// Compile a format's Input.pattern once and share it between searches.
+func getInputPattern(format_data *hsdata.FormattingData) (*regexp.Regexp, error) {
+	patterns := format_data.Input["pattern"]
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("format has no Input.pattern")
+	}
+	if cached, ok := inputPatterns.Load(patterns[0]); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+	pattern, err := regexp.Compile(patterns[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid Input.pattern %q: %v", patterns[0], err)
+	}
+	inputPatterns.Store(patterns[0], pattern)
+	return pattern, nil
+}
+
+func getMismatchPolicy(format_data *hsdata.FormattingData) string {
+	if policy := format_data.Input["on_mismatch"]; len(policy) > 0 {
+		return policy[0]
+	}
+	return MismatchSkip
+}
+
+func getFallbackKey(format_data *hsdata.FormattingData) string {
+	if key := format_data.Input["fallback_key"]; len(key) > 0 {
+		return key[0]
+	}
+	return "command"
+}
+
+// Check that a pattern format can be used, so mistakes are reported once
+// before searching rather than on every line.
+func validateInputPattern(format_data *hsdata.FormattingData) error {
+	inputType := getInputType(format_data)
+	if inputType != InputTypePattern && inputType != InputTypeJSON {
+		return nil
+	}
+	if inputType == InputTypePattern {
+		if _, err := getInputPattern(format_data); err != nil {
+			return err
+		}
+	}
+	switch policy := getMismatchPolicy(format_data); policy {
+	case MismatchSkip, MismatchRaw, MismatchFallback:
+	default:
+		return fmt.Errorf("unknown Input.on_mismatch %q (use %s, %s or %s)", policy, MismatchSkip, MismatchRaw, MismatchFallback)
+	}
+	return nil
+}
+
+// The names of the groups in Input.pattern, plus the fallback key.
+func patternKeys(format_data *hsdata.FormattingData) []string {
+	keys := make([]string, 0)
+	pattern, err := getInputPattern(format_data)
+	if err != nil {
+		return keys
+	}
+	for _, name := range pattern.SubexpNames() {
+		if name != "" && !containsString(keys, name) {
+			keys = append(keys, name)
+		}
+	}
+	if getMismatchPolicy(format_data) == MismatchFallback && !containsString(keys, getFallbackKey(format_data)) {
+		keys = append(keys, getFallbackKey(format_data))
+	}
+	return keys
+}
+
+// Split a line with Input.pattern. The second result is false when the line
+// does not match, in which case the fields follow the mismatch policy.
+func parsePatternLine(line string, format_data *hsdata.FormattingData) (MapFormat, bool) {
+	words := make(MapFormat)
+	pattern, err := getInputPattern(format_data)
+	if err != nil {
+		return words, false
+	}
+	groups := pattern.FindStringSubmatch(line)
+	if groups == nil {
+		if getMismatchPolicy(format_data) == MismatchFallback {
+			words[getFallbackKey(format_data)] = line
+		}
+		return words, false
+	}
+	for i, name := range pattern.SubexpNames() {
+		if name != "" {
+			words[name] = groups[i]
+		}
+	}
+	return words, true
+}
+
+// Split a line into the fields of its format, also returning the mismatch
+// policy to apply to it, or "" if the line can be parsed normally. Only
+// pattern and json formats have lines that do not fit.
+func parseInputLine(line string, format_data *hsdata.FormattingData) (MapFormat, string) {
+	var words MapFormat
+	var ok bool
+	switch getInputType(format_data) {
+	case InputTypePattern:
+		words, ok = parsePatternLine(line, format_data)
+	case InputTypeJSON:
+		words, ok = parseJSONLine(line, format_data)
+	default:
+		return getInputNames(line, format_data), ""
+	}
+	if ok {
+		return words, ""
+	}
+	return words, getMismatchPolicy(format_data)
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// Take an exclusive lock on an open file, waiting for any other process that
+// holds it. The returned function releases it.
+func lockFile(f *os.File) (func(), error) {
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return nil, err
+	}
+	return func() { syscall.Flock(int(f.Fd()), syscall.LOCK_UN) }, nil
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Placeholders in default_logs.file_pattern. When searching they match any
+// value, and when writing a log they are filled in for the current shell and
+// day, so one pattern names both the files to search and today's file.
+var filePatternPlaceholders = []struct {
+	name string
+	glob string
+	fill func(shell string, now time.Time) string
+}{
+	{"{SHELL}", "*", func(shell string, now time.Time) string { return shell }},
+	{"{HOST}", "*", func(shell string, now time.Time) string { return HostName() }},
+	{"{YYYY}", "[0-9][0-9][0-9][0-9]", func(shell string, now time.Time) string { return now.Format("2006") }},
+	{"{MM}", "[0-9][0-9]", func(shell string, now time.Time) string { return now.Format("01") }},
+	{"{DD}", "[0-9][0-9]", func(shell string, now time.Time) string { return now.Format("02") }},
+}
+
+// The file pattern as a glob matching every log it could name.
+func FilePatternGlob(pattern string) string {
+	for _, placeholder := range filePatternPlaceholders {
+		pattern = strings.ReplaceAll(pattern, placeholder.name, placeholder.glob)
+	}
+	return pattern
+}
+
+// The file pattern filled in for a shell at a time, e.g. the name of today's
+// log. Fails if anything else in the pattern is a glob.
+func FilePatternName(pattern string, shell string, now time.Time) (string, error) {
+	for _, placeholder := range filePatternPlaceholders {
+		pattern = strings.ReplaceAll(pattern, placeholder.name, placeholder.fill(shell, now))
+	}
+	if strings.ContainsAny(pattern, "*?[") {
+		return "", fmt.Errorf("default_logs.file_pattern %q does not name a single file", pattern)
+	}
+	return pattern, nil
+}
+
+// The log file to write to now, in the default log directory.
+func TodayLogFile(config *Config, shell string) (string, error) {
+	name, err := FilePatternName(config.DefaultLogs.FilePattern, shell, time.Now())
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(config.DefaultLogs.Directory, name), nil
+}
+
+// The name of the shell running histgrep, from $SHELL.
+func CurrentShell() string {
+	return filepath.Base(os.Getenv("SHELL"))
+}
+
+// The short name of this machine, as used for {HOST}.
+func HostName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return strings.SplitN(host, ".", 2)[0]
+}
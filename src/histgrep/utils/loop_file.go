@@ -1,10 +1,9 @@
 package utils
 
 import (
-	"bufio"
-	"fmt"
 	"io"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
 
@@ -12,53 +11,46 @@ import (
 	// log "github.com/sirupsen/logrus"
 )
 
-type searchTerm struct {
-	term        string
-	conditional string
-}
-
 func LoopFile(hsDat *hsdata.HsData, write_fn hsdata.WriteFn, currentLine hsdata.HsLine) ([]string, error) {
 	Log.Tracef("%v: Loop file: %v\n", CallerName(0), hsDat)
 	Log.Debugf("Input: %v, Output: %v, Color: %v, Excludes: %v\n", hsDat.FormatData.Input, hsDat.FormatData.Output, hsDat.FormatData.Color, hsDat.FormatData.Excludes)
 
-	hasConditionalTerms := false
-	searchTerms := make([]searchTerm, 0)
+	plan, err := newSearchPlan(hsDat)
+	if err != nil {
+		return nil, err
+	}
+	out := &resultWriter{hsDat: hsDat, write_fn: write_fn, currentLine: &currentLine, lastSource: -1}
+	out.begin()
 
-	for _, term := range hsDat.Terms {
-		if strings.HasPrefix(term, "^") {
-			hasConditionalTerms = true
-			searchTerms = append(searchTerms, searchTerm{term: strings.TrimPrefix(term, "^"), conditional: "StartsWith"})
-		} else if strings.HasSuffix(term, "$") {
-			hasConditionalTerms = true
-			searchTerms = append(searchTerms, searchTerm{term: strings.TrimSuffix(term, "$"), conditional: "EndsWith"})
-		} else {
-			searchTerms = append(searchTerms, searchTerm{term: term, conditional: "Contains"})
-		}
+	jobs := hsDat.Jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
 	}
-	_, ok := hsDat.Reader.(*BufferedInput)
-	if !ok {
-		var err error
-		hsDat.Reader, err = GetScanner(hsDat)
+	if jobs > 1 && hsDat.InputFile == "default_files" && len(hsDat.Files) > 1 {
+		scanFilesParallel(hsDat.Files, plan, jobs, out)
+		return out.finish(), nil
+	}
+
+	reader, ok := hsDat.Reader.(*StreamInput)
+	if ok {
+		reader.Reset()
+	} else {
+		reader, err = GetScanner(hsDat)
 		if err != nil {
 			var formatted_lines []string
 			Log.Fprintf(os.Stderr, "Scanner error: %v\n", err)
 			return formatted_lines, err
 		}
+		hsDat.Reader = reader
 	}
 
-	lines_remaining := true
-	matchFound := false
-	lineCount := 0
-	for lines_remaining {
-		var line string
-		var err error
-		switch r := hsDat.Reader.(type) {
-		case *bufio.Reader:
-			line, err = r.ReadString('\n')
-			line, _ = strings.CutSuffix(line, "\n")
-		case *BufferedInput:
-			line, err = r.ReadLine()
-		}
+	records := newRecordSource(reader, &hsDat.FormatData)
+	source := -1
+	scanner := newLineScanner(plan, func(result scanResult) {
+		out.write(source, result)
+	})
+	for {
+		line, err := records.ReadLine()
 		if err != nil {
 			if err == io.EOF {
 				break
@@ -66,145 +58,423 @@ func LoopFile(hsDat *hsdata.HsData, write_fn hsdata.WriteFn, currentLine hsdata.
 			Log.Printf("Read: %s, error: %v\n", line, err)
 			continue
 		}
-		do_write := true
-		if len(hsDat.ExcludeTerms) > 0 {
-			for _, term := range hsDat.ExcludeTerms {
-				var searchLine string
-				var searchTerm string
-				if !hsDat.CaseSensitive {
-					searchLine = strings.ToLower(line)
-					searchTerm = strings.ToLower(term)
-				} else {
-					searchLine = line
-					searchTerm = term
-				}
+		if records.Source() != source {
+			// Context never spans two files.
+			source = records.Source()
+			scanner.reset(records.SourceName())
+		}
+		scanner.scan(line, records.LineNumber())
+	}
+	return out.finish(), nil
+}
 
-				if strings.Contains(searchLine, searchTerm) {
-					do_write = false
-					break
-				}
-			}
+// The compiled filters for one search, shared by every file being scanned.
+type searchPlan struct {
+	hsDat       *hsdata.HsData
+	query       matcher
+	excludes    matcher
+	timeRange   *timeFilter
+	needsFields bool
+	searchKey   string
+	unescape    bool
+	highlight   *highlighter
+}
+
+func newSearchPlan(hsDat *hsdata.HsData) (*searchPlan, error) {
+	if err := validateInputPattern(&hsDat.FormatData); err != nil {
+		return nil, err
+	}
+	query, err := compileQuery(hsDat.Terms, hsDat)
+	if err != nil {
+		return nil, err
+	}
+	excludes, err := compileExcludes(hsDat.ExcludeTerms, hsDat)
+	if err != nil {
+		return nil, err
+	}
+	timeRange, err := newTimeFilter(hsDat)
+	if err != nil {
+		return nil, err
+	}
+	searchKey := getSearchKey(&hsDat.FormatData)
+	needsFields := (query != nil && query.needsFields()) || (excludes != nil && excludes.needsFields()) || timeRange != nil || searchKey != ""
+	highlight := newHighlighter(query, searchKey, hsDat.CaseSensitive)
+	// Rich logs are matched with their escapes decoded.
+	unescape := getInputType(&hsDat.FormatData) == InputTypeRich
+	return &searchPlan{hsDat: hsDat, query: query, excludes: excludes, timeRange: timeRange, needsFields: needsFields, searchKey: searchKey, unescape: unescape, highlight: highlight}, nil
+}
+
+// A line being scanned. It is split into fields the first time they are
+// needed, and the fields are kept for matching and then formatting it.
+type parsedLine struct {
+	text     string
+	words    MapFormat
+	mismatch string
+	parsed   bool
+}
+
+func (l *parsedLine) parse(format_data *hsdata.FormattingData) {
+	if !l.parsed {
+		l.words, l.mismatch = parseInputLine(l.text, format_data)
+		l.parsed = true
+	}
+}
+
+func (l *parsedLine) fields(format_data *hsdata.FormattingData) MapFormat {
+	l.parse(format_data)
+	return l.words
+}
+
+// The mismatch policy for the line, or "" if it fits the format. Only pattern
+// and json lines can fail to fit, so other lines are not split to find out.
+func (l *parsedLine) mismatchPolicy(format_data *hsdata.FormattingData) string {
+	switch getInputType(format_data) {
+	case InputTypePattern, InputTypeJSON:
+		l.parse(format_data)
+		return l.mismatch
+	}
+	return ""
+}
+
+// Check a line against the search. Lines mentioning histgrep itself are
+// hidden completely (not even shown as context) while searching.
+func (p *searchPlan) matchLine(record *parsedLine) (matched bool, hidden bool) {
+	if record.mismatchPolicy(&p.hsDat.FormatData) == MismatchSkip {
+		return false, true
+	}
+	line := record.text
+	if p.unescape {
+		line = recordUnescaper.Replace(line)
+	}
+	var wordsMap MapFormat
+	if p.needsFields {
+		wordsMap = record.fields(&p.hsDat.FormatData)
+	}
+	if p.searchKey != "" {
+		line = wordsMap[p.searchKey]
+	}
+	if p.excludes != nil && p.excludes.match(line, wordsMap, p.hsDat.CaseSensitive) {
+		return false, false
+	}
+	if p.timeRange != nil && !p.timeRange.match(wordsMap) {
+		return false, false
+	}
+	if p.query != nil {
+		if strings.Contains(line, "histgrep") {
+			return false, true
 		}
+		return p.query.match(line, wordsMap, p.hsDat.CaseSensitive), false
+	}
+	return true, false
+}
 
-		currentLine.Line = line
+// Printed between groups of matches when context lines are shown.
+const GroupSeparator = "--"
 
-		if len(searchTerms) > 0 {
-			if strings.Contains(line, "histgrep") {
-				continue
-			}
+// One formatted line of output from scanning a file, before it is numbered.
+type scanResult struct {
+	line        string
+	file        string
+	lineNo      int
+	isContext   bool
+	isSeparator bool
+}
 
-			// First pass: Basic contains check for all terms.
-			for _, term := range searchTerms {
-				searchLine := line
-				searchTerm := term.term
-				if !hsDat.CaseSensitive {
-					searchLine = strings.ToLower(line)
-					searchTerm = strings.ToLower(term.term)
-				}
+type contextLine struct {
+	line   *parsedLine
+	index  int
+	lineNo int
+}
 
-				if !strings.Contains(searchLine, searchTerm) {
-					do_write = false
-					break
-				}
-			}
+// Scans the lines of a single file, keeping track of the lines needed for
+// context around each match.
+type lineScanner struct {
+	plan           *searchPlan
+	emit           func(scanResult)
+	file           string
+	before         []contextLine
+	afterRemaining int
+	lastWritten    int
+	index          int
+}
 
-			// Second pass: Conditional checks for startsWith/endsWith for matching lines
-			if do_write && hasConditionalTerms {
-				wordsMap := getInputNames(line, &hsDat.FormatData)
-
-				for _, term := range searchTerms {
-					if term.conditional == "StartsWith" || term.conditional == "EndsWith" {
-						conditionalMatchFound := false
-						for _, currentSegment := range wordsMap {
-							searchSegment := currentSegment
-							searchTerm := term.term
-							if !hsDat.CaseSensitive {
-								searchSegment = strings.ToLower(currentSegment)
-								searchTerm = strings.ToLower(term.term)
-							}
-
-							if term.conditional == "StartsWith" && strings.HasPrefix(searchSegment, searchTerm) {
-								conditionalMatchFound = true
-								break
-							}
-							if term.conditional == "EndsWith" && strings.HasSuffix(searchSegment, searchTerm) {
-								conditionalMatchFound = true
-								break
-							}
-						}
-						if !conditionalMatchFound {
-							do_write = false
-							break
-						}
-					}
-				}
-			}
+func newLineScanner(plan *searchPlan, emit func(scanResult)) *lineScanner {
+	s := &lineScanner{plan: plan, emit: emit}
+	s.reset("")
+	return s
+}
+
+// Start a new file.
+func (s *lineScanner) reset(file string) {
+	s.file = file
+	s.before = make([]contextLine, 0, s.plan.hsDat.ContextBefore)
+	s.afterRemaining = 0
+	s.lastWritten = -1
+	s.index = -1
+}
+
+// Scan a record, given the line number it starts on in the original file.
+func (s *lineScanner) scan(line string, lineNo int) {
+	hsDat := s.plan.hsDat
+	s.index++
+	record := &parsedLine{text: line}
+	matched, hidden := s.plan.matchLine(record)
+	if hidden {
+		return
+	}
+	if matched {
+		for _, ctx := range s.before {
+			s.writeLine(ctx.line, ctx.index, ctx.lineNo, true)
 		}
-		if do_write {
-			lineCount++
-			matchFound = true
-			if (hsDat.FormatData.Output["keys"])[0] != "BLANK" {
-				wordsMap := getInputNames(currentLine.Line, &hsDat.FormatData)
-				Log.Tracef("%+v\n", wordsMap)
-				currentLine.Line = FormatLine(&wordsMap, &hsDat.FormatData, hsDat.NoColor)
-				if hsDat.IncludeNumbers {
-					numberStr := strconv.Itoa(lineCount)
-					padding := 4 - len(numberStr)
-					if padding > 0 {
-						numberStr = strings.Repeat(" ", padding) + numberStr
-					}
-					currentLine.Line = numberStr + "| " + currentLine.Line
-				}
-				Log.Debugf("%s\n", currentLine)
-			}
-			if currentLine.Line != "" {
-				write_fn(&currentLine)
-			}
+		s.before = s.before[:0]
+		s.writeLine(record, s.index, lineNo, false)
+		s.afterRemaining = hsDat.ContextAfter
+	} else if s.afterRemaining > 0 {
+		s.afterRemaining--
+		s.writeLine(record, s.index, lineNo, true)
+	} else if hsDat.ContextBefore > 0 {
+		if len(s.before) == hsDat.ContextBefore {
+			s.before = append(s.before[:0], s.before[1:]...)
 		}
+		s.before = append(s.before, contextLine{line: record, index: s.index, lineNo: lineNo})
 	}
-	if !matchFound {
-		return []string{"No matches found for the given terms"}, nil
+}
+
+// Emit a matching or context line, preceded by a group separator when context
+// is on and lines were skipped since the last one emitted.
+func (s *lineScanner) writeLine(line *parsedLine, index int, lineNo int, isContext bool) {
+	hsDat := s.plan.hsDat
+	if hasContext(hsDat) && s.lastWritten >= 0 && index > s.lastWritten+1 {
+		s.emit(scanResult{isSeparator: true})
 	}
-	return currentLine.OutLines, nil
+	s.lastWritten = index
+	s.emit(scanResult{
+		line:      formatOutputLine(line, s.plan, isContext, s.file, lineNo),
+		file:      s.file,
+		lineNo:    lineNo,
+		isContext: isContext,
+	})
 }
 
-func GetScanner(hsDat *hsdata.HsData) (interface{}, error) {
-	if hsDat.InputFile == "stdin" {
-		var lines []string
-		scanner := bufio.NewScanner(os.Stdin)
-		for scanner.Scan() {
-			lines = append(lines, scanner.Text())
+func hasContext(hsDat *hsdata.HsData) bool {
+	return hsDat.ContextBefore > 0 || hsDat.ContextAfter > 0
+}
+
+// Writes scan results in order, numbering matches and separating the results
+// of different files when context is shown.
+type resultWriter struct {
+	hsDat       *hsdata.HsData
+	write_fn    hsdata.WriteFn
+	currentLine *hsdata.HsLine
+	lineCount   int
+	matchFound  bool
+	lastSource  int
+	// The last json record, held back until we know whether it needs a
+	// trailing comma.
+	pending *scanResult
+}
+
+func (w *resultWriter) begin() {
+	if header := outputHeader(w.hsDat); header != "" {
+		w.emit(header, "", 0)
+	}
+}
+
+func (w *resultWriter) write(source int, result scanResult) {
+	if isStructuredOutput(w.hsDat) {
+		w.writeRecord(result)
+		return
+	}
+	if source != w.lastSource {
+		if w.lastSource >= 0 && hasContext(w.hsDat) && !result.isSeparator {
+			w.writeSeparator()
 		}
-		if err := scanner.Err(); err != nil {
-			return nil, err
+		w.lastSource = source
+	}
+	if result.isSeparator {
+		w.writeSeparator()
+		return
+	}
+	if !result.isContext {
+		w.lineCount++
+		w.matchFound = true
+	}
+	if result.line == "" {
+		return
+	}
+	w.currentLine.Line = numberLine(result.line, w.hsDat, w.lineCount, result.isContext)
+	w.currentLine.File = result.file
+	w.currentLine.LineNo = result.lineNo
+	w.write_fn(w.currentLine)
+}
+
+// Structured output has no separators or numbering. Json records are
+// written as the elements of one array.
+func (w *resultWriter) writeRecord(result scanResult) {
+	if result.isSeparator {
+		return
+	}
+	if !result.isContext {
+		w.lineCount++
+		w.matchFound = true
+	}
+	if result.line == "" {
+		return
+	}
+	if w.hsDat.OutputFormat != OutputJSON {
+		w.emit(result.line, result.file, result.lineNo)
+		return
+	}
+	if w.pending != nil {
+		w.emit("  "+w.pending.line+",", w.pending.file, w.pending.lineNo)
+	}
+	w.pending = &result
+}
+
+func (w *resultWriter) emit(text string, file string, lineNo int) {
+	w.currentLine.Line = text
+	w.currentLine.File, w.currentLine.LineNo = file, lineNo
+	w.write_fn(w.currentLine)
+}
+
+func (w *resultWriter) writeSeparator() {
+	w.currentLine.Line = GroupSeparator
+	w.currentLine.File, w.currentLine.LineNo = "", 0
+	if !w.hsDat.NoColor {
+		w.currentLine.Line = hsdata.ColorContext + GroupSeparator + hsdata.ColorNone
+	}
+	w.write_fn(w.currentLine)
+}
+
+func (w *resultWriter) finish() []string {
+	if w.hsDat.OutputFormat == OutputJSON {
+		if w.pending != nil {
+			w.emit("  "+w.pending.line, w.pending.file, w.pending.lineNo)
 		}
-		if len(lines) == 0 {
-			// If stdin is empty, use default files
-			return getBufferedInputFromFiles(hsDat.Files)
+		w.emit("]", "", 0)
+		return w.currentLine.OutLines
+	}
+	if !w.matchFound {
+		return []string{"No matches found for the given terms"}
+	}
+	return w.currentLine.OutLines
+}
+
+// Output keys holding the source file and original line number of a line.
+// Formats can place and colour them like any other key.
+const FileKey = "FILE"
+const LineNumberKey = "LINENO"
+
+// Format a line for output. Context lines are drawn in the context colour and
+// the search terms are highlighted in matching lines. The file name and line
+// number are shown in front of the line when asked for and the format does not
+// already place them.
+func formatOutputLine(record *parsedLine, plan *searchPlan, isContext bool, file string, lineNo int) string {
+	hsDat := plan.hsDat
+	line := record.text
+	if isStructuredOutput(hsDat) {
+		return encodeRecord(line, record.fields(&hsDat.FormatData), hsDat, isContext, file, lineNo)
+	}
+	prefix := formatLocation(hsDat, isContext, file, lineNo)
+	if isBlankOutput(&hsDat.FormatData) || record.mismatchPolicy(&hsDat.FormatData) == MismatchRaw {
+		if hsDat.NoColor {
+			return prefix + line
 		}
-		return &BufferedInput{content: lines}, nil
-	} else if hsDat.InputFile == "default_files" {
-		return getBufferedInputFromFiles(hsDat.Files)
-	} else {
-		file, err := os.Open(hsDat.InputFile)
-		if err != nil {
-			return nil, err
+		if isContext {
+			return prefix + hsdata.ColorContext + line + hsdata.ColorNone
 		}
-		return bufio.NewReader(file), nil
+		return prefix + highlightText(line, plan.highlight.linePatterns(), matchColor(&hsDat.FormatData), "")
 	}
+	wordsMap := record.fields(&hsDat.FormatData)
+	wordsMap[FileKey] = file
+	wordsMap[LineNumberKey] = strconv.Itoa(lineNo)
+	Log.Tracef("%+v\n", wordsMap)
+	if isContext {
+		line = FormatContextLine(&wordsMap, &hsDat.FormatData, hsDat.NoColor)
+	} else {
+		line = formatLine(&wordsMap, &hsDat.FormatData, hsDat.NoColor, false, plan.highlight)
+	}
+	Log.Debugf("%s\n", line)
+	if line == "" {
+		return ""
+	}
+	return prefix + line
 }
 
-func getBufferedInputFromFiles(files []string) (*BufferedInput, error) {
-	var allLines []string
-	for _, file := range files {
-		content, err := os.ReadFile(file)
-		if err != nil {
-			return nil, fmt.Errorf("error reading file %s: %v", file, err)
+// The grep style file:line: prefix for --with-filename and --line-number.
+// Context lines use - instead of :.
+func formatLocation(hsDat *hsdata.HsData, isContext bool, file string, lineNo int) string {
+	outputKeys := hsDat.FormatData.Output["keys"]
+	separator := ":"
+	if isContext {
+		separator = "-"
+	}
+	colors := hsDat.FormatData.Color
+	location := ""
+	if hsDat.WithFilename && !containsString(outputKeys, FileKey) {
+		location += colorText(file, keyColor(colors, FileKey, "blue"), hsDat.NoColor) + separator
+	}
+	if hsDat.LineNumbers && !containsString(outputKeys, LineNumberKey) {
+		location += colorText(strconv.Itoa(lineNo), keyColor(colors, LineNumberKey, "green"), hsDat.NoColor) + separator
+	}
+	return location
+}
+
+func keyColor(colors map[string]map[string]string, key string, fallback string) string {
+	if color_map, ok := colors[key]; ok {
+		return color_map["default"]
+	}
+	return fallback
+}
+
+func colorText(text string, color string, no_color bool) string {
+	if no_color {
+		return text
+	}
+	return InsertColor(color) + text + hsdata.ColorNone
+}
+
+// Formats whose output is the whole line as it was read: Output.keys is
+// ["BLANK"] or missing.
+func isBlankOutput(format_data *hsdata.FormattingData) bool {
+	keys := format_data.Output["keys"]
+	return len(keys) == 0 || keys[0] == "BLANK"
+}
+
+// Numbered lines show the match count, with a blank number for context lines.
+func numberLine(line string, hsDat *hsdata.HsData, lineCount int, isContext bool) string {
+	if !hsDat.IncludeNumbers || isBlankOutput(&hsDat.FormatData) {
+		return line
+	}
+	numberStr := strconv.Itoa(lineCount)
+	if isContext {
+		numberStr = ""
+	}
+	padding := 4 - len(numberStr)
+	if padding > 0 {
+		numberStr = strings.Repeat(" ", padding) + numberStr
+	}
+	return numberStr + "| " + line
+}
+
+func GetScanner(hsDat *hsdata.HsData) (*StreamInput, error) {
+	var sources []string
+	if hsDat.InputFile == "stdin" {
+		if _, err := Stdin.Peek(1); err == io.EOF {
+			// If stdin is empty, use default files
+			sources = hsDat.Files
+		} else {
+			sources = []string{StdinSource}
+		}
+	} else if hsDat.InputFile == "default_files" {
+		sources = hsDat.Files
+	} else {
+		if _, err := os.Stat(hsDat.InputFile); err != nil {
+			return nil, err
 		}
-		lines := strings.Split(string(content), "\n")
-		allLines = append(allLines, lines...)
+		sources = []string{hsDat.InputFile}
 	}
-	return &BufferedInput{content: allLines}, nil
+	return NewStreamInput(sources, hsDat.UsePager), nil
 }
 
 func WriteLine(line *hsdata.HsLine) {
@@ -226,60 +496,45 @@ func SaveLine(line *hsdata.HsLine) {
 type MapFormat map[string]string
 
 func FormatLine(terms *MapFormat, format_data *hsdata.FormattingData, no_color bool) string {
+	return formatLine(terms, format_data, no_color, false, nil)
+}
+
+// Format a line shown as context around a match. Every key and separator uses
+// the CONTEXT colour from the format, or a dim default.
+func FormatContextLine(terms *MapFormat, format_data *hsdata.FormattingData, no_color bool) string {
+	return formatLine(terms, format_data, no_color, true, nil)
+}
+
+func formatLine(terms *MapFormat, format_data *hsdata.FormattingData, no_color bool, is_context bool, highlight *highlighter) string {
 	f_keys := (*format_data).Output["keys"]
 	f_separators := (*format_data).Output["separators"]
 	f_colors := format_data.Color
-	f_excludes := (*format_data).Excludes
 	Log.Debugf("Terms: %v, Names: %v, Separators: %v\n", terms, f_keys, f_separators)
 	var line string = ""
+	contextColor := hsdata.ColorContext
+	if color_map, ok := f_colors["CONTEXT"]; ok {
+		contextColor = InsertColor(color_map["default"])
+	}
+	colorize := func(color string) string {
+		if is_context {
+			return contextColor
+		}
+		return InsertColor(color)
+	}
+	if isExcluded(terms, format_data) {
+		return ""
+	}
+	match := matchColor(format_data)
 	sep_len := len(f_separators)
 	for i, term := range f_keys {
-		excludes, ok := f_excludes[term]
-		if ok {
-			starts_with, ok := excludes["starts_with"]
-			if ok {
-				for _, exclude := range starts_with {
-					if strings.HasPrefix((*terms)[term], exclude) {
-						return ""
-					}
-				}
-			}
-			contains, ok := excludes["contains"]
-			if ok {
-				for _, exclude := range contains {
-					if strings.Contains((*terms)[term], exclude) {
-						return ""
-					}
-				}
-			}
-			ends_with, ok := excludes["ends_with"]
-			if ok {
-				for _, exclude := range ends_with {
-					if strings.HasSuffix((*terms)[term], exclude) {
-						return ""
-					}
-				}
-			}
-		}
-		color_map, ok := f_colors[term]
-		if ok {
-			color := color_map["default"]
-			for key, try_color := range color_map {
-				if strings.Contains((*terms)[term], key) {
-					color = try_color
-					break
-				}
-			}
-			if !no_color {
-				line += InsertColor(color)
-			}
+		// Keys without a colour use the terminal's default.
+		color := keyColorFor(term, (*terms)[term], format_data)
+		if no_color {
+			line += (*terms)[term]
 		} else {
-			color := "white"
-			if !no_color {
-				line += InsertColor(color)
-			}
+			fieldColor := colorize(color)
+			line += fieldColor + highlightText((*terms)[term], highlight.patternsFor(term), match, fieldColor)
 		}
-		line += (*terms)[term]
 
 		if !no_color {
 			line += hsdata.ColorNone
@@ -288,7 +543,7 @@ func FormatLine(terms *MapFormat, format_data *hsdata.FormattingData, no_color b
 			color_map, ok := f_colors["SEPARATOR"]
 			if ok {
 				if !no_color {
-					line += InsertColor(color_map["default"])
+					line += colorize(color_map["default"])
 				}
 			} else {
 				if !no_color {
@@ -305,20 +560,92 @@ func FormatLine(terms *MapFormat, format_data *hsdata.FormattingData, no_color b
 	return line
 }
 
-func InsertColor(color string) string {
-	if color == "red" {
-		return hsdata.ColorRed
-	} else if color == "green" {
-		return hsdata.ColorGreen
-	} else if color == "blue" {
-		return hsdata.ColorBlue
-	} else if color == "grey" {
-		return hsdata.ColorGrey
+// Whether the format's Excludes remove a line, checking each output key.
+func isExcluded(terms *MapFormat, format_data *hsdata.FormattingData) bool {
+	for _, term := range format_data.Output["keys"] {
+		excludes, ok := format_data.Excludes[term]
+		if !ok {
+			continue
+		}
+		for _, condition := range Conditions {
+			for _, exclude := range excludes[condition] {
+				if matchCondition(condition, exclude, (*terms)[term]) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// The input type of a format, set with Input.type. Empty for formats that
+// split lines with Input.keys and Input.separators.
+func getInputType(format_data *hsdata.FormattingData) string {
+	if inputType := (*format_data).Input["type"]; len(inputType) > 0 {
+		return inputType[0]
+	}
+	if len((*format_data).Input["pattern"]) > 0 {
+		return InputTypePattern
+	}
+	return ""
+}
+
+// The field that terms without a field: prefix are matched against. Shell
+// history records search only the command, so timestamps and other metadata
+// do not cause false matches. Empty means the whole line.
+func getSearchKey(format_data *hsdata.FormattingData) string {
+	switch getInputType(format_data) {
+	case InputTypeZsh, InputTypeBash, InputTypeFish:
+		return "command"
+	}
+	return ""
+}
+
+// The names of the fields produced by getInputNames for a format.
+func getInputKeys(format_data *hsdata.FormattingData) []string {
+	switch getInputType(format_data) {
+	case InputTypeZsh:
+		return ZshKeys
+	case InputTypeBash:
+		return BashKeys
+	case InputTypeFish:
+		return FishKeys
+	case InputTypeRich:
+		return RichKeys
+	case InputTypePattern:
+		return patternKeys(format_data)
+	case InputTypeJSON:
+		keys := (*format_data).Input["keys"]
+		if getMismatchPolicy(format_data) == MismatchFallback && !containsString(keys, getFallbackKey(format_data)) {
+			keys = append(keys[:len(keys):len(keys)], getFallbackKey(format_data))
+		}
+		return keys
 	}
-	return hsdata.ColorNone
+	return (*format_data).Input["keys"]
 }
 
 func getInputNames(line string, format_data *hsdata.FormattingData) MapFormat {
+	switch getInputType(format_data) {
+	case InputTypeZsh:
+		return parseZshRecord(line)
+	case InputTypeBash:
+		return parseBashRecord(line)
+	case InputTypeFish:
+		return parseFishRecord(line)
+	case InputTypeRich:
+		return parseRichRecord(line)
+	case InputTypePattern:
+		words, _ := parsePatternLine(line, format_data)
+		return words
+	case InputTypeJSON:
+		words, _ := parseJSONLine(line, format_data)
+		return words
+	}
+	return splitBySeparators(line, format_data)
+}
+
+// Split a line into the format's Input.keys using Input.separators in order.
+func splitBySeparators(line string, format_data *hsdata.FormattingData) MapFormat {
 	Log.Debugf("%v: Line: %v, Keys: %v, Separators: %v\n", CallerName(0), line, (*format_data).Input["keys"], (*format_data).Input["separators"])
 	keys := (*format_data).Input["keys"]
 	separators := (*format_data).Input["separators"]
@@ -367,21 +694,3 @@ func getInputNames(line string, format_data *hsdata.FormattingData) MapFormat {
 	}
 	return words
 }
-
-type BufferedInput struct {
-	content []string
-	index   int
-}
-
-func (bi *BufferedInput) ReadLine() (string, error) {
-	if bi.index >= len(bi.content) {
-		return "", io.EOF
-	}
-	line := bi.content[bi.index]
-	bi.index++
-	return line, nil
-}
-
-func (bi *BufferedInput) Reset() {
-	bi.index = 0
-}
@@ -0,0 +1,11 @@
+package utils
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	SetVerbosity(0)
+	os.Exit(m.Run())
+}
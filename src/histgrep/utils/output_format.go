@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/TJN25/histgrep/hsdata"
+)
+
+// Values for --output-format. Text is the coloured output of FormatLine, the
+// others write the parsed fields of each line for use in other programs.
+const OutputText = "text"
+const OutputJSON = "json"
+const OutputNDJSON = "ndjson"
+const OutputCSV = "csv"
+const OutputTSV = "tsv"
+
+var OutputFormats = []string{OutputText, OutputJSON, OutputNDJSON, OutputCSV, OutputTSV}
+
+// Extra columns in structured output. LINE holds the whole line for formats
+// whose output keys are BLANK, and CONTEXT marks context lines when -A, -B or
+// -C are used.
+const RawLineKey = "LINE"
+const ContextKey = "CONTEXT"
+
+func ValidateOutputFormat(name string) error {
+	if name == "" || containsString(OutputFormats, name) {
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q (expected one of %s)", name, strings.Join(OutputFormats, ", "))
+}
+
+func isStructuredOutput(hsDat *hsdata.HsData) bool {
+	return hsDat.OutputFormat != "" && hsDat.OutputFormat != OutputText
+}
+
+// The columns written for each line: the format's output keys, then the
+// source file and line number unless the format already places them.
+func outputColumns(hsDat *hsdata.HsData) []string {
+	columns := []string{RawLineKey}
+	if !isBlankOutput(&hsDat.FormatData) {
+		columns = columns[:0]
+		for _, key := range hsDat.FormatData.Output["keys"] {
+			if !containsString(columns, key) {
+				columns = append(columns, key)
+			}
+		}
+	}
+	for _, key := range []string{FileKey, LineNumberKey} {
+		if !containsString(columns, key) {
+			columns = append(columns, key)
+		}
+	}
+	if hasContext(hsDat) {
+		columns = append(columns, ContextKey)
+	}
+	return columns
+}
+
+// The line written before any records: a header row for csv and tsv, or the
+// opening bracket of the json array. Empty if nothing is needed.
+func outputHeader(hsDat *hsdata.HsData) string {
+	switch hsDat.OutputFormat {
+	case OutputJSON:
+		return "["
+	case OutputCSV, OutputTSV:
+		return encodeRow(outputColumns(hsDat), hsDat.OutputFormat)
+	}
+	return ""
+}
+
+// Serialise the fields of a line in the --output-format. Returns "" for lines
+// removed by the format's Excludes.
+func encodeRecord(line string, wordsMap MapFormat, hsDat *hsdata.HsData, isContext bool, file string, lineNo int) string {
+	if isExcluded(&wordsMap, &hsDat.FormatData) {
+		return ""
+	}
+	if isBlankOutput(&hsDat.FormatData) {
+		// Only BLANK formats write LINE, so an input key of the same name
+		// is kept in every other format.
+		wordsMap[RawLineKey] = line
+	}
+	wordsMap[FileKey] = file
+	wordsMap[LineNumberKey] = strconv.Itoa(lineNo)
+	wordsMap[ContextKey] = strconv.FormatBool(isContext)
+
+	columns := outputColumns(hsDat)
+	if hsDat.OutputFormat == OutputJSON || hsDat.OutputFormat == OutputNDJSON {
+		return encodeObject(columns, wordsMap)
+	}
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		values[i] = wordsMap[column]
+	}
+	return encodeRow(values, hsDat.OutputFormat)
+}
+
+// A JSON object with its keys in column order. The line number is a number
+// and the context marker a boolean, everything else is a string.
+func encodeObject(columns []string, wordsMap MapFormat) string {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, column := range columns {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(jsonString(column))
+		buf.WriteByte(':')
+		value := wordsMap[column]
+		switch column {
+		case LineNumberKey, ContextKey:
+			buf.WriteString(value)
+		default:
+			buf.WriteString(jsonString(value))
+		}
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+func jsonString(value string) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// One csv row (quoted as needed) or tsv row (tabs, newlines and backslashes
+// escaped with a backslash), without the trailing newline.
+func encodeRow(values []string, outputFormat string) string {
+	if outputFormat == OutputTSV {
+		escaped := make([]string, len(values))
+		for i, value := range values {
+			escaped[i] = tsvEscaper.Replace(value)
+		}
+		return strings.Join(escaped, "\t")
+	}
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(values)
+	writer.Flush()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+var tsvEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
@@ -26,8 +26,14 @@ type Model struct {
 	commandMode    bool
 	commandInput   textinput.Model
 	VimExit        bool
+	searchErr      string
+	contextBefore  int
+	contextAfter   int
 }
 
+// Lines of context shown when toggling context on in the pager without -A/-B/-C.
+const DefaultPagerContext = 2
+
 func initialModel(content []string, data *hsdata.HsData, line hsdata.HsLine, config *Config) Model {
 	ti := textinput.New()
 	ti.Placeholder = "Enter terms..."
@@ -39,15 +45,22 @@ func initialModel(content []string, data *hsdata.HsData, line hsdata.HsLine, con
 	ci.CharLimit = 500
 	ci.Prompt = ":"
 
+	contextBefore, contextAfter := data.ContextBefore, data.ContextAfter
+	if contextBefore == 0 && contextAfter == 0 {
+		contextBefore, contextAfter = DefaultPagerContext, DefaultPagerContext
+	}
+
 	return Model{
-		Content:      content,
-		colorProfile: termenv.ColorProfile(),
-		data:         data,
-		line:         line,
-		terms:        data.Terms,
-		searchInput:  ti,
-		commandInput: ci,
-		VimExit:      config.Display.VimExit,
+		Content:       content,
+		colorProfile:  termenv.ColorProfile(),
+		data:          data,
+		line:          line,
+		terms:         data.Terms,
+		searchInput:   ti,
+		commandInput:  ci,
+		VimExit:       config != nil && config.Display.VimExit,
+		contextBefore: contextBefore,
+		contextAfter:  contextAfter,
 	}
 }
 
@@ -90,33 +103,11 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			switch msg.Type {
 			case tea.KeyEnter:
 				m.searchMode = false
-				terms := strings.Fields(m.searchInput.Value())
-				if m.searchExcludes {
-					m.data.ExcludeTerms = terms
-				} else {
-					m.data.Terms = terms
-				}
-				if bufferedInput, ok := m.data.Reader.(*BufferedInput); ok {
-					bufferedInput.Reset()
-				}
-				m.Content, _ = LoopFile(m.data, SaveLine, m.line)
-				print(m.Content[0])
-				m.cursor = 0
+				m.runSearch(m.promptTerms())
 				return m, tea.Batch(tea.ClearScreen, tea.EnterAltScreen)
 			case tea.KeySpace:
-				terms := strings.Fields(m.searchInput.Value())
-				if m.searchExcludes {
-					m.data.ExcludeTerms = terms
-				} else {
-					m.data.Terms = terms
-				}
-				if bufferedInput, ok := m.data.Reader.(*BufferedInput); ok {
-					bufferedInput.Reset()
-				}
-				m.Content, _ = LoopFile(m.data, SaveLine, m.line)
-				print(m.Content[0])
-				m.cursor = 0
-				terms_string := strings.Join(terms, " ") + " "
+				m.runSearch(m.promptTerms())
+				terms_string := m.searchInput.Value() + " "
 				m.searchInput.SetValue(terms_string)
 				m.searchInput.CursorEnd()
 				return m, tea.Batch(tea.ClearScreen, tea.EnterAltScreen)
@@ -124,18 +115,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				terms_string := m.searchInput.Value()
 				if len(terms_string) > 1 {
 					if terms_string[len(terms_string)-1] == ' ' {
-						terms := strings.Fields(m.searchInput.Value())
-						if m.searchExcludes {
-							m.data.ExcludeTerms = terms
-						} else {
-							m.data.Terms = terms
-						}
-						if bufferedInput, ok := m.data.Reader.(*BufferedInput); ok {
-							bufferedInput.Reset()
-						}
-						m.Content, _ = LoopFile(m.data, SaveLine, m.line)
-						print(m.Content[0])
-						m.cursor = 0
+						m.runSearch(m.promptTerms())
 
 					}
 					terms_string = terms_string[:len(terms_string)-1]
@@ -143,18 +123,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.searchInput.CursorEnd()
 				} else {
 
-					terms := strings.Fields(m.searchInput.Value())
-					if m.searchExcludes {
-						m.data.ExcludeTerms = terms
-					} else {
-						m.data.Terms = terms
-					}
-					if bufferedInput, ok := m.data.Reader.(*BufferedInput); ok {
-						bufferedInput.Reset()
-					}
-					m.Content, _ = LoopFile(m.data, SaveLine, m.line)
-					print(m.Content[0])
-					m.cursor = 0
+					m.runSearch(m.promptTerms())
 					terms_string = ""
 					m.searchInput.SetValue(terms_string)
 					m.searchInput.CursorEnd()
@@ -226,6 +195,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.searchInput.Focus()
 			m.searchInput.CursorEnd()
 			return m, textinput.Blink
+		case "c":
+			m.toggleContext()
+			return m, tea.Batch(tea.ClearScreen, tea.EnterAltScreen)
 		case ":":
 			m.commandMode = true
 			m.commandInput.Focus()
@@ -238,6 +210,48 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// The terms typed at the / or ? prompt, passed on whole so a regular
+// expression such as re:git (push|pull) .*origin stays one term.
+func (m *Model) promptTerms() []string {
+	return []string{m.searchInput.Value()}
+}
+
+// Re-run the search with the given terms. A term that fails to compile (e.g. a
+// half-typed regular expression) keeps the previous results on screen.
+func (m *Model) runSearch(terms []string) {
+	previousTerms, previousExcludes := m.data.Terms, m.data.ExcludeTerms
+	if m.searchExcludes {
+		m.data.ExcludeTerms = terms
+	} else {
+		m.data.Terms = terms
+	}
+	content, err := LoopFile(m.data, SaveLine, m.line)
+	if err != nil {
+		m.data.Terms, m.data.ExcludeTerms = previousTerms, previousExcludes
+		m.searchErr = err.Error()
+		return
+	}
+	m.searchErr = ""
+	m.Content = content
+	m.cursor = 0
+}
+
+// Switch context lines around matches on or off and re-run the current search.
+func (m *Model) toggleContext() {
+	if m.data.ContextBefore > 0 || m.data.ContextAfter > 0 {
+		m.data.ContextBefore, m.data.ContextAfter = 0, 0
+	} else {
+		m.data.ContextBefore, m.data.ContextAfter = m.contextBefore, m.contextAfter
+	}
+	content, err := LoopFile(m.data, SaveLine, m.line)
+	if err != nil {
+		m.searchErr = err.Error()
+		return
+	}
+	m.Content = content
+	m.cursor = 0
+}
+
 // How the screen is rendered
 func (m Model) View() string {
 	if m.viewportHeight == 0 {
@@ -269,10 +283,13 @@ func (m Model) View() string {
 		} else {
 			statusLine = boldStyle.Styled("Search: ") + m.searchInput.View()
 		}
+		if m.searchErr != "" {
+			statusLine += " " + regularStyle.Styled(m.searchErr)
+		}
 	} else {
 
 		terms := boldStyle.Styled(strings.Join(m.terms, ", "))
-		statusInfo := regularStyle.Styled(fmt.Sprintf(" line %d of %d | Searching for terms: %s | Excluding terms: %s | (use '/' to search and '?' to exclude or press q to quit)", m.cursor+1, len(m.Content), strings.Join(m.terms, ", "), strings.Join(m.data.ExcludeTerms, ", ")))
+		statusInfo := regularStyle.Styled(fmt.Sprintf(" line %d of %d | Searching for terms: %s | Excluding terms: %s | (use '/' to search, '?' to exclude, 'c' to toggle context or press q to quit)", m.cursor+1, len(m.Content), strings.Join(m.terms, ", "), strings.Join(m.data.ExcludeTerms, ", ")))
 
 		statusLine = statusStyle.Styled(fmt.Sprintf("%s%s", terms, statusInfo))
 	}
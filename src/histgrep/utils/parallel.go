@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"io"
+	"sync"
+)
+
+// The results of scanning one file in a parallel search.
+type fileResults struct {
+	results []scanResult
+	done    chan struct{}
+}
+
+// Scan files concurrently with a pool of workers, writing the results in file
+// order. At most 2*jobs files are held in memory waiting to be written, so a
+// slow file early in the list does not let the rest of the results pile up.
+func scanFilesParallel(files []string, plan *searchPlan, jobs int, out *resultWriter) {
+	Log.Debugf("Scanning %d files with %d workers\n", len(files), jobs)
+	pending := make([]*fileResults, len(files))
+	for i := range pending {
+		pending[i] = &fileResults{done: make(chan struct{})}
+	}
+	window := make(chan struct{}, 2*jobs)
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				pending[i].results = scanFile(files[i], plan)
+				close(pending[i].done)
+			}
+		}()
+	}
+	go func() {
+		for i := range files {
+			window <- struct{}{}
+			indexes <- i
+		}
+		close(indexes)
+	}()
+
+	for i, file := range pending {
+		<-file.done
+		for _, result := range file.results {
+			out.write(i, result)
+		}
+		pending[i] = nil
+		<-window
+	}
+	wg.Wait()
+}
+
+func scanFile(file string, plan *searchPlan) []scanResult {
+	results := make([]scanResult, 0)
+	reader := NewStreamInput([]string{file}, false)
+	defer reader.Close()
+	scanner := newLineScanner(plan, func(result scanResult) {
+		results = append(results, result)
+	})
+	scanner.reset(file)
+	records := newRecordSource(reader, &plan.hsDat.FormatData)
+	for {
+		line, err := records.ReadLine()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			Log.Printf("Read: %s, error: %v\n", line, err)
+			continue
+		}
+		scanner.scan(line, records.LineNumber())
+	}
+	return results
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/TJN25/histgrep/hsdata"
+)
+
+// Write logs in the layout of testFormat, with every seventh command a
+// docker one.
+func writeTestLogs(tb testing.TB, files int, lines int) []string {
+	tb.Helper()
+	dir := tb.TempDir()
+	paths := make([]string, 0, files)
+	for f := 0; f < files; f++ {
+		var contents strings.Builder
+		for l := 0; l < lines; l++ {
+			command := fmt.Sprintf("git status %d", l)
+			if l%7 == 0 {
+				command = fmt.Sprintf("docker run --rm image-%d", l)
+			}
+			fmt.Fprintf(&contents, "/srv/project-%d: %s\n", f, command)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("log-%03d.log", f))
+		if err := os.WriteFile(path, []byte(contents.String()), 0o644); err != nil {
+			tb.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+var testFormat = hsdata.FormattingData{
+	Input: map[string][]string{
+		"keys":       {"directory", "command"},
+		"separators": {": "},
+	},
+	Output: map[string][]string{
+		"keys":       {"command", "directory"},
+		"separators": {" # "},
+	},
+}
+
+func searchTestLogs(tb testing.TB, files []string, jobs int, contextLines int) []string {
+	tb.Helper()
+	hsDat := &hsdata.HsData{
+		InputFile:     "default_files",
+		Files:         files,
+		Terms:         []string{"docker"},
+		FormatData:    testFormat,
+		NoColor:       true,
+		Jobs:          jobs,
+		ContextBefore: contextLines,
+		ContextAfter:  contextLines,
+		WithFilename:  true,
+		LineNumbers:   true,
+	}
+	lines, err := LoopFile(hsDat, SaveLine, hsdata.HsLine{})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return lines
+}
+
+func TestParallelOutputMatchesSequential(t *testing.T) {
+	files := writeTestLogs(t, 12, 200)
+	for _, contextLines := range []int{0, 2} {
+		sequential := searchTestLogs(t, files, 1, contextLines)
+		parallel := searchTestLogs(t, files, 4, contextLines)
+		if len(sequential) == 0 {
+			t.Fatalf("no results with %d context lines", contextLines)
+		}
+		if !reflect.DeepEqual(sequential, parallel) {
+			t.Errorf("parallel output with %d context lines differs from sequential output (%d lines vs %d)", contextLines, len(parallel), len(sequential))
+		}
+	}
+}
+
+func benchmarkScan(b *testing.B, jobs int) {
+	files := writeTestLogs(b, 16, 5000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		searchTestLogs(b, files, jobs, 0)
+	}
+}
+
+func BenchmarkScanSequential(b *testing.B) {
+	benchmarkScan(b, 1)
+}
+
+func BenchmarkScanParallel(b *testing.B) {
+	benchmarkScan(b, 4)
+}
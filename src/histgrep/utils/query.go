@@ -0,0 +1,534 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/TJN25/histgrep/hsdata"
+)
+
+// A compiled search query. Leaves are single search terms and branches combine
+// them with AND, OR and NOT.
+type matcher interface {
+	match(line string, words MapFormat, caseSensitive bool) bool
+	needsFields() bool
+	// The terms that make a line match, for highlighting. Negated terms are
+	// left out.
+	positiveTerms() []*searchTerm
+}
+
+type termMatcher struct {
+	term searchTerm
+}
+
+type andMatcher struct {
+	children []matcher
+}
+
+type orMatcher struct {
+	children []matcher
+}
+
+type notMatcher struct {
+	child matcher
+}
+
+func (m *termMatcher) match(line string, words MapFormat, caseSensitive bool) bool {
+	return m.term.matches(line, words, caseSensitive)
+}
+
+func (m *termMatcher) needsFields() bool {
+	return m.term.needsFields()
+}
+
+func (m *termMatcher) positiveTerms() []*searchTerm {
+	return []*searchTerm{&m.term}
+}
+
+func (m *andMatcher) match(line string, words MapFormat, caseSensitive bool) bool {
+	for _, child := range m.children {
+		if !child.match(line, words, caseSensitive) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *andMatcher) needsFields() bool {
+	return anyNeedsFields(m.children)
+}
+
+func (m *andMatcher) positiveTerms() []*searchTerm {
+	return allPositiveTerms(m.children)
+}
+
+func (m *orMatcher) match(line string, words MapFormat, caseSensitive bool) bool {
+	for _, child := range m.children {
+		if child.match(line, words, caseSensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *orMatcher) needsFields() bool {
+	return anyNeedsFields(m.children)
+}
+
+func (m *orMatcher) positiveTerms() []*searchTerm {
+	return allPositiveTerms(m.children)
+}
+
+func (m *notMatcher) match(line string, words MapFormat, caseSensitive bool) bool {
+	return !m.child.match(line, words, caseSensitive)
+}
+
+func (m *notMatcher) needsFields() bool {
+	return m.child.needsFields()
+}
+
+func (m *notMatcher) positiveTerms() []*searchTerm {
+	return nil
+}
+
+func allPositiveTerms(children []matcher) []*searchTerm {
+	var terms []*searchTerm
+	for _, child := range children {
+		terms = append(terms, child.positiveTerms()...)
+	}
+	return terms
+}
+
+func anyNeedsFields(children []matcher) bool {
+	for _, child := range children {
+		if child.needsFields() {
+			return true
+		}
+	}
+	return false
+}
+
+type queryTokenKind int
+
+const (
+	tokenTerm queryTokenKind = iota
+	tokenPhrase
+	tokenOr
+	tokenAnd
+	tokenNot
+	tokenOpen
+	tokenClose
+)
+
+type queryToken struct {
+	kind queryTokenKind
+	text string
+}
+
+// Split the arguments of a query into tokens. Whitespace separates terms,
+// "..." is a phrase, parentheses group, a leading - negates and OR/AND/NOT are
+// operators. Parentheses inside a term (re:(push|pull)) are kept as part of the
+// term, and a regular expression term runs to the end of its argument, so
+// 're:git (push|pull) .*origin' is one term.
+func tokenizeQuery(args []string) ([]queryToken, error) {
+	tokens := make([]queryToken, 0)
+	for _, arg := range args {
+		argTokens, err := tokenizeArg(arg)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, argTokens...)
+	}
+	return tokens, nil
+}
+
+func tokenizeArg(query string) ([]queryToken, error) {
+	tokens := make([]queryToken, 0)
+	runes := []rune(query)
+	i := 0
+	for i < len(runes) {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, queryToken{kind: tokenOpen, text: "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, queryToken{kind: tokenClose, text: ")"})
+			i++
+		case c == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]):
+			tokens = append(tokens, queryToken{kind: tokenNot, text: "-"})
+			i++
+		case c == '"':
+			phrase, next, err := readQuoted(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, queryToken{kind: tokenPhrase, text: phrase})
+			i = next
+		default:
+			word, next, err := readWord(runes, i, isRegexTerm(string(runes[i:])))
+			if err != nil {
+				return nil, err
+			}
+			i = next
+			switch word {
+			case "OR":
+				tokens = append(tokens, queryToken{kind: tokenOr, text: word})
+			case "AND":
+				tokens = append(tokens, queryToken{kind: tokenAnd, text: word})
+			case "NOT":
+				tokens = append(tokens, queryToken{kind: tokenNot, text: word})
+			default:
+				tokens = append(tokens, queryToken{kind: tokenTerm, text: word})
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// Read a "quoted phrase" starting at runes[start], returning the unquoted text
+// and the index after the closing quote.
+func readQuoted(runes []rune, start int) (string, int, error) {
+	var phrase strings.Builder
+	for i := start + 1; i < len(runes); i++ {
+		if runes[i] == '\\' && i+1 < len(runes) && runes[i+1] == '"' {
+			phrase.WriteRune('"')
+			i++
+			continue
+		}
+		if runes[i] == '"' {
+			return phrase.String(), i + 1, nil
+		}
+		phrase.WriteRune(runes[i])
+	}
+	return "", 0, fmt.Errorf("unterminated quote in query: %s", string(runes[start:]))
+}
+
+// Whether the text starts with a re: term, possibly scoped to a field
+// (command:re:^git).
+func isRegexTerm(text string) bool {
+	if strings.HasPrefix(text, RegexPrefix) {
+		return true
+	}
+	groups := fieldPrefix.FindStringSubmatch(text)
+	return groups != nil && strings.HasPrefix(groups[2], RegexPrefix)
+}
+
+// Read a bare term up to whitespace or an unbalanced closing parenthesis.
+// Regular expression terms keep their whitespace and only end at an
+// unbalanced closing parenthesis or the end of the argument. Quoted sections
+// inside the term (command:"prod cluster") are unquoted.
+func readWord(runes []rune, start int, regex bool) (string, int, error) {
+	var word strings.Builder
+	depth := 0
+	i := start
+	for i < len(runes) {
+		c := runes[i]
+		if unicode.IsSpace(c) && !regex {
+			break
+		}
+		if c == '"' {
+			phrase, next, err := readQuoted(runes, i)
+			if err != nil {
+				return "", 0, err
+			}
+			word.WriteString(phrase)
+			i = next
+			continue
+		}
+		if c == '(' {
+			depth++
+		} else if c == ')' {
+			if depth == 0 {
+				break
+			}
+			depth--
+		}
+		word.WriteRune(c)
+		i++
+	}
+	if regex {
+		return strings.TrimRightFunc(word.String(), unicode.IsSpace), i, nil
+	}
+	return word.String(), i, nil
+}
+
+// Rewrite the search arguments from the command line so an argument the shell
+// kept together stays one term: git push becomes "git push" and
+// command:git push becomes command:"git push". Arguments with parentheses,
+// quotes, OR/AND/NOT or a re: term are left to the query parser.
+func QueryArgs(args []string) []string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, quoteArg(arg))
+	}
+	return quoted
+}
+
+func quoteArg(arg string) string {
+	text := strings.TrimSpace(arg)
+	if !strings.ContainsFunc(text, unicode.IsSpace) || hasQuerySyntax(text) {
+		return arg
+	}
+	// Keep the field prefix and ^, \ and $ outside the quotes so they still
+	// apply to the phrase.
+	lead := ""
+	if groups := fieldPrefix.FindStringSubmatch(text); groups != nil {
+		lead = groups[1] + ":"
+		text = groups[2]
+	}
+	if strings.HasPrefix(text, "^") || strings.HasPrefix(text, "\\") {
+		lead += text[:1]
+		text = text[1:]
+	}
+	trail := ""
+	if strings.HasSuffix(text, "$") {
+		trail = "$"
+		text = strings.TrimSuffix(text, "$")
+	}
+	if text == "" || strings.HasSuffix(text, "\\") {
+		return arg
+	}
+	return lead + `"` + text + `"` + trail
+}
+
+func hasQuerySyntax(text string) bool {
+	if strings.ContainsAny(text, `()"`) || isRegexTerm(text) {
+		return true
+	}
+	for _, word := range strings.Fields(text) {
+		if word == "OR" || word == "AND" || word == "NOT" {
+			return true
+		}
+	}
+	return false
+}
+
+// Split a list of terms on whitespace, keeping "quoted phrases" together.
+func SplitTerms(terms string) []string {
+	items := make([]string, 0)
+	var curr strings.Builder
+	inQuote := false
+	for i, c := range terms {
+		if c == '"' && (i == 0 || terms[i-1] != '\\') {
+			inQuote = !inQuote
+		}
+		if unicode.IsSpace(c) && !inQuote {
+			if curr.Len() > 0 {
+				items = append(items, curr.String())
+				curr.Reset()
+			}
+			continue
+		}
+		curr.WriteRune(c)
+	}
+	if curr.Len() > 0 {
+		items = append(items, curr.String())
+	}
+	return items
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+	hsDat  *hsdata.HsData
+}
+
+// Compile a query such as (kubectl OR k) apply -dry-run "prod cluster" into a
+// matcher tree. An empty query returns a nil matcher, which matches everything.
+// With --regex each argument is one regular expression and all of them must
+// match.
+func compileQuery(args []string, hsDat *hsdata.HsData) (matcher, error) {
+	if hsDat.UseRegex {
+		return compileRegexArgs(args, hsDat)
+	}
+	query := strings.Join(args, " ")
+	tokens, err := tokenizeQuery(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	parser := queryParser{tokens: tokens, hsDat: hsDat}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(parser.tokens) {
+		return nil, fmt.Errorf("unexpected %q in query: %s", parser.tokens[parser.pos].text, query)
+	}
+	return root, nil
+}
+
+func compileRegexArgs(args []string, hsDat *hsdata.HsData) (matcher, error) {
+	children := make([]matcher, 0)
+	for _, arg := range args {
+		arg = strings.TrimSpace(arg)
+		if arg == "" {
+			continue
+		}
+		term, err := parseSearchTerm(arg, hsDat)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, &termMatcher{term: term})
+	}
+	switch len(children) {
+	case 0:
+		return nil, nil
+	case 1:
+		return children[0], nil
+	}
+	return &andMatcher{children: children}, nil
+}
+
+// Compile exclude terms into a single matcher that matches when any of them
+// do. Exclude terms are a plain list of terms and "quoted phrases", or of
+// regular expressions with --regex, so operators and groups are rejected.
+func compileExcludes(args []string, hsDat *hsdata.HsData) (matcher, error) {
+	children := make([]matcher, 0)
+	for _, arg := range args {
+		if hsDat.UseRegex {
+			child, err := compileRegexArgs(SplitTerms(arg), hsDat)
+			if err != nil {
+				return nil, err
+			}
+			if child != nil {
+				children = append(children, child)
+			}
+			continue
+		}
+		tokens, err := tokenizeArg(arg)
+		if err != nil {
+			return nil, err
+		}
+		for _, token := range tokens {
+			switch token.kind {
+			case tokenPhrase:
+				term := searchTerm{term: token.text, conditional: "Contains"}
+				children = append(children, &termMatcher{term: term.fold(hsDat.CaseSensitive)})
+			case tokenTerm:
+				term, err := parseSearchTerm(token.text, hsDat)
+				if err != nil {
+					return nil, err
+				}
+				children = append(children, &termMatcher{term: term})
+			default:
+				return nil, fmt.Errorf("exclude terms are a plain list, so %q cannot be used in %q (add -term or NOT term to the search query instead)", token.text, arg)
+			}
+		}
+	}
+	if len(children) == 0 {
+		return nil, nil
+	}
+	return &orMatcher{children: children}, nil
+}
+
+func (p *queryParser) peek() *queryToken {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+	return &p.tokens[p.pos]
+}
+
+func (p *queryParser) parseOr() (matcher, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	children := []matcher{first}
+	for {
+		token := p.peek()
+		if token == nil || token.kind != tokenOr {
+			break
+		}
+		p.pos++
+		next, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, next)
+	}
+	if len(children) == 1 {
+		return first, nil
+	}
+	return &orMatcher{children: children}, nil
+}
+
+func (p *queryParser) parseAnd() (matcher, error) {
+	children := make([]matcher, 0)
+	for {
+		token := p.peek()
+		if token == nil || token.kind == tokenOr || token.kind == tokenClose {
+			break
+		}
+		if token.kind == tokenAnd {
+			p.pos++
+			continue
+		}
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+	if len(children) == 0 {
+		return nil, p.unexpected("expected a search term")
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return &andMatcher{children: children}, nil
+}
+
+func (p *queryParser) parseUnary() (matcher, error) {
+	token := p.peek()
+	if token == nil {
+		return nil, p.unexpected("expected a search term")
+	}
+	switch token.kind {
+	case tokenNot:
+		p.pos++
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notMatcher{child: child}, nil
+	case tokenOpen:
+		p.pos++
+		child, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		closing := p.peek()
+		if closing == nil || closing.kind != tokenClose {
+			return nil, p.unexpected("missing closing parenthesis")
+		}
+		p.pos++
+		return child, nil
+	case tokenPhrase:
+		p.pos++
+		term := searchTerm{term: token.text, conditional: "Contains"}
+		return &termMatcher{term: term.fold(p.hsDat.CaseSensitive)}, nil
+	case tokenTerm:
+		p.pos++
+		term, err := parseSearchTerm(token.text, p.hsDat)
+		if err != nil {
+			return nil, err
+		}
+		return &termMatcher{term: term}, nil
+	}
+	return nil, p.unexpected("expected a search term")
+}
+
+func (p *queryParser) unexpected(msg string) error {
+	token := p.peek()
+	if token == nil {
+		return fmt.Errorf("%s at end of query", msg)
+	}
+	return fmt.Errorf("%s before %q", msg, token.text)
+}
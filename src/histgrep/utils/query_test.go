@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/TJN25/histgrep/hsdata"
+)
+
+func TestCompileQueryRegex(t *testing.T) {
+	format := hsdata.FormattingData{
+		Input: map[string][]string{
+			"keys":       {"directory", "command"},
+			"separators": {": "},
+		},
+	}
+	tests := []struct {
+		name     string
+		terms    []string
+		useRegex bool
+		line     string
+		want     bool
+	}{
+		{"regex flag", []string{"git (push|pull) .*origin"}, true, "/src: git push -u origin main", true},
+		{"regex flag keeps order", []string{"git (push|pull) .*origin"}, true, "/src: origin foo git push", false},
+		{"regex flag per argument", []string{"git", "origin$"}, true, "/src: origin git", false},
+		{"regex flag ands arguments", []string{"git", "origin"}, true, "/src: origin git", true},
+		{"regex flag ands arguments no match", []string{"^/src", "docker"}, true, "/src: git push", false},
+		{"re prefix", []string{"re:git (push|pull) .*origin"}, false, "/src: git pull --rebase origin", true},
+		{"re prefix from the pager prompt", []string{"re:git (push|pull) .*origin"}, false, "/src: git push -u origin main", true},
+		{"re prefix keeps order", []string{"re:git (push|pull) .*origin"}, false, "/src: origin foo git push", false},
+		{"field re prefix", []string{"command:re:^git (push|pull)"}, false, "/src: git push", true},
+		{"field re prefix other field", []string{"command:re:^git (push|pull)"}, false, "git push: ls", false},
+		{"re prefix with other terms", []string{"re:^git (push|pull)", "main"}, false, "/src: git push origin main", true},
+		{"re prefix ends with its argument", []string{"(re:^git (push|pull)", "OR", "docker)", "origin"}, false, "/src: docker pull origin", true},
+		{"re prefix ends at its group", []string{"(docker OR re:^git (push|pull)) origin"}, false, "/src: git push origin", true},
+		{"re prefix ends at its group no match", []string{"(docker OR re:^git (push|pull)) origin"}, false, "/src: git fetch origin", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hsDat := &hsdata.HsData{FormatData: format, UseRegex: tt.useRegex}
+			query, err := compileQuery(tt.terms, hsDat)
+			if err != nil {
+				t.Fatalf("compileQuery(%q) failed: %v", tt.terms, err)
+			}
+			words := getInputNames(tt.line, &format)
+			if got := query.match(tt.line, words, false); got != tt.want {
+				t.Errorf("compileQuery(%q) on %q = %v, want %v", tt.terms, tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryArgs(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"git", "git"},
+		{"git push", `"git push"`},
+		{"command:git push", `command:"git push"`},
+		{"^git push", `^"git push"`},
+		{"git push$", `"git push"$`},
+		{"(kubectl OR k) apply", "(kubectl OR k) apply"},
+		{"docker OR podman", "docker OR podman"},
+		{`apply "prod cluster"`, `apply "prod cluster"`},
+		{"re:git (push|pull) .*origin", "re:git (push|pull) .*origin"},
+		{"re:git push", "re:git push"},
+	}
+	for _, tt := range tests {
+		if got := QueryArgs([]string{tt.arg})[0]; got != tt.want {
+			t.Errorf("QueryArgs(%q) = %q, want %q", tt.arg, got, tt.want)
+		}
+	}
+}
+
+func TestQuotedArgumentIsOneTerm(t *testing.T) {
+	format := hsdata.FormattingData{
+		Input: map[string][]string{
+			"keys":       {"directory", "command"},
+			"separators": {": "},
+		},
+	}
+	tests := []struct {
+		args []string
+		line string
+		want bool
+	}{
+		{[]string{"git push"}, "/src: git push -u origin main", true},
+		{[]string{"push git"}, "/src: git push -u origin main", false},
+		{[]string{"command:git push"}, "/src: git push", true},
+		{[]string{"command:git push"}, "git push: ls", false},
+		{[]string{"docker OR podman"}, "/src: podman ps", true},
+	}
+	for _, tt := range tests {
+		hsDat := &hsdata.HsData{FormatData: format}
+		query, err := compileQuery(QueryArgs(tt.args), hsDat)
+		if err != nil {
+			t.Fatalf("compileQuery(%q) failed: %v", tt.args, err)
+		}
+		words := getInputNames(tt.line, &format)
+		if got := query.match(tt.line, words, false); got != tt.want {
+			t.Errorf("compileQuery(%q) on %q = %v, want %v", tt.args, tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestCompileExcludes(t *testing.T) {
+	hsDat := &hsdata.HsData{}
+	excludes, err := compileExcludes([]string{`"prod cluster" staging`}, hsDat)
+	if err != nil {
+		t.Fatalf("compileExcludes failed: %v", err)
+	}
+	for line, want := range map[string]bool{
+		"kubectl apply --context prod cluster": true,
+		"deploy staging":                       true,
+		"kubectl apply --context prod":         false,
+	} {
+		if got := excludes.match(line, nil, false); got != want {
+			t.Errorf("excludes on %q = %v, want %v", line, got, want)
+		}
+	}
+	for _, arg := range []string{"(foo OR bar)", "foo OR bar", "NOT foo"} {
+		if _, err := compileExcludes([]string{arg}, hsDat); err == nil {
+			t.Errorf("compileExcludes(%q) succeeded, want an error", arg)
+		}
+	}
+}
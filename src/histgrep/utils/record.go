@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Written in place of the parts of a command matched by record.redact.
+const Redacted = "[REDACTED]"
+
+// One command for histgrep record to log.
+type RecordEntry struct {
+	Start    time.Time
+	Host     string
+	Dir      string
+	Status   int
+	Duration int
+	Command  string
+}
+
+// Input type of the logs histgrep record writes: fields separated by
+// RichSeparator with their escapes decoded.
+const InputTypeRich = "rich"
+
+// Keys exposed by the rich log parser, in the order the fields are written.
+var RichKeys = []string{"date", "time", "host", "directory", "status", "duration", "command"}
+
+// Newlines, carriage returns and tabs are written as \n, \r and \t so an
+// entry stays on one line and its fields stay apart, and backslashes as \\ so
+// a command that contains a literal \n can be told apart from one that spans
+// two lines.
+var recordEscaper = strings.NewReplacer("\\", `\\`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// Reverses recordEscaper.
+var recordUnescaper = strings.NewReplacer(`\\`, "\\", `\n`, "\n", `\r`, "\r", `\t`, "\t")
+
+// The entry as a line in the layout of the rich format.
+func (e RecordEntry) Line() string {
+	fields := []string{
+		e.Start.Format("2006-01-02"),
+		e.Start.Format("15:04:05"),
+		e.Host,
+		e.Dir,
+		strconv.Itoa(e.Status),
+		strconv.Itoa(e.Duration),
+		strings.TrimRight(e.Command, "\r\n"),
+	}
+	for i, field := range fields {
+		fields[i] = recordEscaper.Replace(field)
+	}
+	return strings.Join(fields, RichSeparator) + "\n"
+}
+
+// Split a line written by histgrep record into its fields, decoding the
+// escapes so multi-line commands are searched and shown as they were run.
+func parseRichRecord(line string) MapFormat {
+	words := make(MapFormat, len(RichKeys))
+	fields := strings.SplitN(line, RichSeparator, len(RichKeys))
+	for i, key := range RichKeys {
+		words[key] = ""
+		if i < len(fields) {
+			words[key] = recordUnescaper.Replace(fields[i])
+		}
+	}
+	return words
+}
+
+// The [record] rules of a config, compiled.
+type recordRules struct {
+	ignore []*regexp.Regexp
+	redact []*regexp.Regexp
+}
+
+func compileRecordRules(config *Config) (recordRules, error) {
+	rules := recordRules{}
+	for _, pattern := range config.Record.Ignore {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return rules, fmt.Errorf("record.ignore: invalid pattern %q: %v", pattern, err)
+		}
+		rules.ignore = append(rules.ignore, re)
+	}
+	for _, pattern := range config.Record.Redact {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return rules, fmt.Errorf("record.redact: invalid pattern %q: %v", pattern, err)
+		}
+		rules.redact = append(rules.redact, re)
+	}
+	return rules, nil
+}
+
+// Check the [record] patterns of a config.
+func ValidateRecordRules(config *Config) error {
+	_, err := compileRecordRules(config)
+	return err
+}
+
+func (r recordRules) ignores(command string) bool {
+	for _, re := range r.ignore {
+		if re.MatchString(command) {
+			return true
+		}
+	}
+	return false
+}
+
+// Replace each match of the redact patterns, or only the groups of a pattern
+// that has any, e.g. `--password[= ](\S+)` keeps the flag and hides the value.
+func (r recordRules) redactCommand(command string) string {
+	for _, re := range r.redact {
+		var redacted strings.Builder
+		last := 0
+		for _, match := range re.FindAllStringSubmatchIndex(command, -1) {
+			spans := match[:2]
+			if len(match) > 2 {
+				spans = match[2:]
+			}
+			for i := 0; i+1 < len(spans); i += 2 {
+				// Skips groups that did not match (-1) and groups inside
+				// ones already replaced.
+				if spans[i] < last {
+					continue
+				}
+				redacted.WriteString(command[last:spans[i]])
+				redacted.WriteString(Redacted)
+				last = spans[i+1]
+			}
+		}
+		redacted.WriteString(command[last:])
+		command = redacted.String()
+	}
+	return command
+}
+
+// Append an entry to today's log for a shell, after applying the [record]
+// rules of the config. Other histgrep record processes writing to the same
+// file wait for the line to be written in full. Returns false if the command
+// was ignored.
+func RecordCommand(config *Config, shell string, entry RecordEntry) (bool, error) {
+	rules, err := compileRecordRules(config)
+	if err != nil {
+		return false, err
+	}
+	if strings.TrimSpace(entry.Command) == "" || rules.ignores(entry.Command) {
+		return false, nil
+	}
+	entry.Command = rules.redactCommand(entry.Command)
+
+	file, err := TodayLogFile(config, shell)
+	if err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return false, err
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	unlock, err := lockFile(f)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	if _, err := f.WriteString(entry.Line()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
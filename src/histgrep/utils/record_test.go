@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TJN25/histgrep/hsdata"
+)
+
+func TestRecordEntryLineEscapes(t *testing.T) {
+	start := time.Date(2024, 1, 31, 15, 4, 5, 0, time.Local)
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"echo hi", `echo hi`},
+		{"printf 'a\nb'", `printf 'a\nb'`},
+		{`printf 'a\nb'`, `printf 'a\\nb'`},
+		{"echo a\tb", `echo a\tb`},
+		{`echo \`, `echo \\`},
+	}
+	for _, tt := range tests {
+		entry := RecordEntry{Start: start, Host: "box", Dir: "/src", Command: tt.command}
+		want := "2024-01-31\t15:04:05\tbox\t/src\t0\t0\t" + tt.want + "\n"
+		if got := entry.Line(); got != want {
+			t.Errorf("Line() for %q = %q, want %q", tt.command, got, want)
+		}
+	}
+}
+
+func TestRecordCommandReadBack(t *testing.T) {
+	config := &Config{}
+	config.DefaultLogs.Directory = t.TempDir()
+	config.DefaultLogs.FilePattern = "{SHELL}.log"
+	for _, command := range []string{"echo a\necho b", `echo \`, `printf 'a\nb'`} {
+		entry := RecordEntry{Start: time.Now(), Host: "box", Dir: "/srv/my\tdir", Status: 1, Duration: 2, Command: command}
+		if _, err := RecordCommand(config, "zsh", entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	search := func(terms []string, outputFormat string) []string {
+		t.Helper()
+		hsDat := &hsdata.HsData{
+			InputFile:    filepath.Join(config.DefaultLogs.Directory, "zsh.log"),
+			Terms:        terms,
+			FormatData:   BuiltinFormats()[RichFormatName],
+			NoColor:      true,
+			OutputFormat: outputFormat,
+		}
+		lines, err := LoopFile(hsDat, SaveLine, hsdata.HsLine{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lines
+	}
+
+	want := []string{
+		"echo a\necho b # /srv/my\tdir [1 2s]",
+		"echo \\ # /srv/my\tdir [1 2s]",
+		"printf 'a\\nb' # /srv/my\tdir [1 2s]",
+	}
+	if got := search(nil, OutputText); !reflect.DeepEqual(got, want) {
+		t.Errorf("read back %q, want %q", got, want)
+	}
+	if got := search([]string{"a\necho"}, OutputText); !reflect.DeepEqual(got, want[:1]) {
+		t.Errorf("searching for a newline found %q, want %q", got, want[:1])
+	}
+	if got := search([]string{`a\nb`}, OutputText); !reflect.DeepEqual(got, want[2:]) {
+		t.Errorf("searching for a literal \\n found %q, want %q", got, want[2:])
+	}
+	// The header, then the first two commands escaped once.
+	got := search([]string{"echo"}, OutputTSV)
+	if len(got) != 3 || !strings.HasPrefix(got[1], `echo a\necho b`+"\t") || !strings.HasPrefix(got[2], `echo \\`+"\t") {
+		t.Errorf("tsv output %q, want each command escaped once", got)
+	}
+}
@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"github.com/TJN25/histgrep/hsdata"
+)
+
+// A source of records to search. Most inputs have one record per line, but
+// shell histories such as zsh's can spread one command over several lines.
+type recordSource interface {
+	ReadLine() (string, error)
+	Source() int
+	SourceName() string
+	LineNumber() int
+}
+
+// Groups the lines of a StreamInput into records for the format's input type.
+// Records never span two sources, and LineNumber reports the first line of
+// the record.
+type recordReader struct {
+	lines      *StreamInput
+	inputType  string
+	pending    *bufferedLine
+	source     int
+	sourceName string
+	lineNo     int
+}
+
+type bufferedLine struct {
+	line       string
+	source     int
+	sourceName string
+	lineNo     int
+}
+
+// Wrap the input in a record reader if the format needs lines grouped.
+func newRecordSource(lines *StreamInput, format_data *hsdata.FormattingData) recordSource {
+	inputType := getInputType(format_data)
+	switch inputType {
+	case InputTypeZsh, InputTypeBash, InputTypeFish:
+		return &recordReader{lines: lines, inputType: inputType}
+	}
+	return lines
+}
+
+func (rr *recordReader) next() (*bufferedLine, error) {
+	if rr.pending != nil {
+		curr := rr.pending
+		rr.pending = nil
+		return curr, nil
+	}
+	line, err := rr.lines.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	return &bufferedLine{line: line, source: rr.lines.Source(), sourceName: rr.lines.SourceName(), lineNo: rr.lines.LineNumber()}, nil
+}
+
+func (rr *recordReader) ReadLine() (string, error) {
+	first, err := rr.next()
+	if err != nil {
+		return "", err
+	}
+	rr.source, rr.sourceName, rr.lineNo = first.source, first.sourceName, first.lineNo
+	record := first.line
+	switch rr.inputType {
+	case InputTypeZsh:
+		for zshContinues(record) {
+			following, err := rr.next()
+			if err != nil {
+				break
+			}
+			if following.source != first.source {
+				rr.pending = following
+				break
+			}
+			record = record[:len(record)-1] + "\n" + following.line
+		}
+		record = zshUnmetafy(record)
+	case InputTypeBash:
+		// A timestamp owns every line up to the next timestamp, which also
+		// keeps multi-line commands (lithist) together.
+		if !isBashTimestamp(record) {
+			break
+		}
+		for {
+			following, err := rr.next()
+			if err != nil {
+				break
+			}
+			if following.source != first.source || isBashTimestamp(following.line) {
+				rr.pending = following
+				break
+			}
+			record += "\n" + following.line
+		}
+	case InputTypeFish:
+		// An entry runs until the next "- cmd:" line.
+		for {
+			following, err := rr.next()
+			if err != nil {
+				break
+			}
+			if following.source != first.source || isFishEntryStart(following.line) {
+				rr.pending = following
+				break
+			}
+			record += "\n" + following.line
+		}
+	}
+	return record, nil
+}
+
+func (rr *recordReader) Source() int {
+	return rr.source
+}
+
+func (rr *recordReader) SourceName() string {
+	return rr.sourceName
+}
+
+func (rr *recordReader) LineNumber() int {
+	return rr.lineNo
+}
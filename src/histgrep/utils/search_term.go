@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/TJN25/histgrep/hsdata"
+)
+
+type searchTerm struct {
+	term        string
+	conditional string
+	field       string
+	pattern     *regexp.Regexp
+}
+
+// RegexPrefix marks a single term as a regular expression, e.g. re:git (push|pull)
+const RegexPrefix = "re:"
+
+// A field-scoped term looks like command:docker. The part before the first
+// colon only scopes the term when it is an input key of the format, so
+// nginx:latest, localhost:8080 and https://example.com are plain terms.
+var fieldPrefix = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_.-]*):(.+)$`)
+
+func compileTermPattern(term string, caseSensitive bool) (*regexp.Regexp, error) {
+	expr := term
+	if !caseSensitive {
+		expr = "(?i)" + term
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression %q: %v", term, err)
+	}
+	return pattern, nil
+}
+
+func parseSearchTerm(term string, hsDat *hsdata.HsData) (searchTerm, error) {
+	parsed := searchTerm{}
+	if strings.HasPrefix(term, "\\") {
+		// A leading backslash searches for the rest of the term literally.
+		parsed.term = strings.TrimPrefix(term, "\\")
+		parsed.conditional = "Contains"
+		return parsed.fold(hsDat.CaseSensitive), nil
+	}
+	if !strings.HasPrefix(term, RegexPrefix) {
+		groups := fieldPrefix.FindStringSubmatch(term)
+		if groups != nil && containsString(getInputKeys(&hsDat.FormatData), groups[1]) {
+			parsed.field = groups[1]
+			term = groups[2]
+		}
+	}
+	if hsDat.UseRegex || strings.HasPrefix(term, RegexPrefix) {
+		term = strings.TrimPrefix(term, RegexPrefix)
+		pattern, err := compileTermPattern(term, hsDat.CaseSensitive)
+		if err != nil {
+			return parsed, err
+		}
+		parsed.term = term
+		parsed.conditional = "Regex"
+		parsed.pattern = pattern
+		return parsed, nil
+	}
+	if strings.HasPrefix(term, "^") {
+		parsed.term = strings.TrimPrefix(term, "^")
+		parsed.conditional = "StartsWith"
+	} else if strings.HasSuffix(term, "$") {
+		parsed.term = strings.TrimSuffix(term, "$")
+		parsed.conditional = "EndsWith"
+	} else {
+		parsed.term = term
+		parsed.conditional = "Contains"
+	}
+	return parsed.fold(hsDat.CaseSensitive), nil
+}
+
+// Lower case plain terms once up front for case insensitive searches.
+func (t searchTerm) fold(caseSensitive bool) searchTerm {
+	if !caseSensitive {
+		t.term = strings.ToLower(t.term)
+	}
+	return t
+}
+
+// Whether the term needs the line split into fields before it can be matched.
+func (t *searchTerm) needsFields() bool {
+	return t.field != "" || t.conditional != "Contains"
+}
+
+func (t *searchTerm) matchText(text string, caseSensitive bool) bool {
+	if t.conditional == "Regex" {
+		return t.pattern.MatchString(text)
+	}
+	if !caseSensitive {
+		text = strings.ToLower(text)
+	}
+	switch t.conditional {
+	case "StartsWith":
+		return strings.HasPrefix(text, t.term)
+	case "EndsWith":
+		return strings.HasSuffix(text, t.term)
+	default:
+		return strings.Contains(text, t.term)
+	}
+}
+
+// Check the term against a line. Field-scoped terms only look inside their
+// field, plain terms look at the whole line, startsWith/endsWith terms look at
+// every field and regular expressions accept either the line or any field.
+func (t *searchTerm) matches(line string, words MapFormat, caseSensitive bool) bool {
+	if t.field != "" {
+		return t.matchText(words[t.field], caseSensitive)
+	}
+	switch t.conditional {
+	case "Contains":
+		return t.matchText(line, caseSensitive)
+	case "Regex":
+		if t.matchText(line, caseSensitive) {
+			return true
+		}
+	}
+	for _, currentSegment := range words {
+		if t.matchText(currentSegment, caseSensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(items []string, item string) bool {
+	for _, curr := range items {
+		if curr == item {
+			return true
+		}
+	}
+	return false
+}
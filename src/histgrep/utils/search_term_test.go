@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/TJN25/histgrep/hsdata"
+)
+
+func TestParseSearchTermFieldPrefix(t *testing.T) {
+	hsDat := &hsdata.HsData{FormatData: hsdata.FormattingData{
+		Input: map[string][]string{
+			"keys":       {"directory", "command"},
+			"separators": {": "},
+		},
+	}}
+	tests := []struct {
+		term  string
+		field string
+		text  string
+		line  string
+	}{
+		{"command:docker", "command", "docker", "/srv: docker ps"},
+		{"directory:^/srv", "directory", "/srv", "/srv: ls"},
+		{"nginx:latest", "", "nginx:latest", "/srv: docker pull nginx:latest"},
+		{"https://example.com", "", "https://example.com", "/srv: curl https://example.com"},
+		{"localhost:8080", "", "localhost:8080", "/srv: curl localhost:8080/health"},
+		{`C:\Users`, "", `c:\users`, `/mnt: dir C:\Users`},
+	}
+	for _, tt := range tests {
+		term, err := parseSearchTerm(tt.term, hsDat)
+		if err != nil {
+			t.Errorf("parseSearchTerm(%q) failed: %v", tt.term, err)
+			continue
+		}
+		if term.field != tt.field || term.term != tt.text {
+			t.Errorf("parseSearchTerm(%q) = field %q term %q, want field %q term %q", tt.term, term.field, term.term, tt.field, tt.text)
+		}
+		words := getInputNames(tt.line, &hsDat.FormatData)
+		if !term.matches(tt.line, words, false) {
+			t.Errorf("%q does not match %q", tt.term, tt.line)
+		}
+	}
+}
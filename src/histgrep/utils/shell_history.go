@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Input types for Input.type in formats.json. Without a type, lines are split
+// using Input.keys and Input.separators.
+const InputTypeZsh = "zsh"
+const InputTypeBash = "bash"
+const InputTypeFish = "fish"
+
+// Keys exposed by the zsh history parser.
+var ZshKeys = []string{"timestamp", "duration", "command"}
+
+// Keys exposed by the bash history parser.
+var BashKeys = []string{"timestamp", "command"}
+
+// Keys exposed by the fish history parser.
+var FishKeys = []string{"command", "when", "paths"}
+
+// zsh marks bytes that are special to it with the Meta byte followed by the
+// original byte XOR 32.
+const zshMeta = 0x83
+
+func zshUnmetafy(text string) string {
+	if strings.IndexByte(text, zshMeta) < 0 {
+		return text
+	}
+	decoded := make([]byte, 0, len(text))
+	for i := 0; i < len(text); i++ {
+		if text[i] == zshMeta && i+1 < len(text) {
+			i++
+			decoded = append(decoded, text[i]^32)
+			continue
+		}
+		decoded = append(decoded, text[i])
+	}
+	return string(decoded)
+}
+
+// A zsh history line ending with an odd number of backslashes continues on
+// the next line.
+func zshContinues(line string) bool {
+	count := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		count++
+	}
+	return count%2 == 1
+}
+
+// Split a zsh extended history record (": 1700000000:0;command") into its
+// timestamp, duration and command. Plain history lines only have a command.
+func parseZshRecord(record string) MapFormat {
+	words := MapFormat{"timestamp": "", "duration": "", "command": record}
+	if !strings.HasPrefix(record, ": ") {
+		return words
+	}
+	header, command, found := strings.Cut(record[2:], ";")
+	if !found {
+		return words
+	}
+	timestamp, duration, _ := strings.Cut(header, ":")
+	words["timestamp"] = strings.TrimSpace(timestamp)
+	words["duration"] = strings.TrimSpace(duration)
+	words["command"] = command
+	return words
+}
+
+// With HISTTIMEFORMAT set, bash writes a #1700000000 comment line before each
+// command.
+var bashTimestampLine = regexp.MustCompile(`^#\d+$`)
+
+func isBashTimestamp(line string) bool {
+	return bashTimestampLine.MatchString(line)
+}
+
+// Split a bash history record into its timestamp and command. Records read
+// from a file with timestamps start with the timestamp line.
+func parseBashRecord(record string) MapFormat {
+	words := MapFormat{"timestamp": "", "command": record}
+	first, rest, found := strings.Cut(record, "\n")
+	if isBashTimestamp(first) {
+		words["timestamp"] = strings.TrimPrefix(first, "#")
+		if found {
+			words["command"] = rest
+		} else {
+			words["command"] = ""
+		}
+	}
+	return words
+}
+
+// Each fish history entry starts with a "- cmd: ..." line followed by
+// indented "when:" and "paths:" lines.
+func isFishEntryStart(line string) bool {
+	return strings.HasPrefix(line, "- cmd:")
+}
+
+// Split a fish history entry into its command, when (seconds since the
+// epoch) and paths (space separated).
+func parseFishRecord(record string) MapFormat {
+	words := MapFormat{"command": "", "when": "", "paths": ""}
+	paths := make([]string, 0)
+	inPaths := false
+	for _, line := range strings.Split(record, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case isFishEntryStart(line):
+			words["command"] = fishUnescape(strings.TrimSpace(strings.TrimPrefix(line, "- cmd:")))
+			inPaths = false
+		case strings.HasPrefix(trimmed, "when:"):
+			words["when"] = strings.TrimSpace(strings.TrimPrefix(trimmed, "when:"))
+			inPaths = false
+		case strings.HasPrefix(trimmed, "paths:"):
+			inPaths = true
+		case inPaths && strings.HasPrefix(trimmed, "- "):
+			paths = append(paths, fishUnescape(strings.TrimPrefix(trimmed, "- ")))
+		}
+	}
+	words["paths"] = strings.Join(paths, " ")
+	return words
+}
+
+// fish escapes backslashes as \\ and newlines as \n in history entries.
+func fishUnescape(text string) string {
+	if !strings.Contains(text, "\\") {
+		return text
+	}
+	var decoded strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) {
+			switch text[i+1] {
+			case 'n':
+				decoded.WriteByte('\n')
+				i++
+				continue
+			case '\\':
+				decoded.WriteByte('\\')
+				i++
+				continue
+			}
+		}
+		decoded.WriteByte(text[i])
+	}
+	return decoded.String()
+}
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The file pattern written by histgrep init, matching the files the shell
+// hooks write to.
+const InitFilePattern = "{SHELL}-history-{YYYY}-{MM}-{DD}.log"
+
+// Marks the start of a hook added to a shell's startup file, so it is only
+// added once.
+const HookMarker = "# histgrep: record each command with its exit status and duration"
+
+var HookShells = []string{"zsh", "bash", "fish"}
+
+// The startup file a shell reads for interactive sessions.
+func ShellRCFile(shell string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	switch shell {
+	case "zsh":
+		return filepath.Join(home, ".zshrc"), nil
+	case "bash":
+		return filepath.Join(home, ".bashrc"), nil
+	case "fish":
+		return filepath.Join(home, ".config", "fish", "config.fish"), nil
+	}
+	return "", fmt.Errorf("unsupported shell %q (use one of %s)", shell, strings.Join(HookShells, ", "))
+}
+
+// Add a hook to the end of a startup file, unless one is already there.
+// Returns false if the file already had a hook.
+func AppendHook(rcFile string, hook string) (bool, error) {
+	contents, err := os.ReadFile(rcFile)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if strings.Contains(string(contents), HookMarker) {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(rcFile), 0o755); err != nil {
+		return false, err
+	}
+	f, err := os.OpenFile(rcFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	prefix := "\n"
+	if len(contents) == 0 || strings.HasSuffix(string(contents), "\n\n") {
+		prefix = ""
+	}
+	_, err = f.WriteString(prefix + hook)
+	return true, err
+}
+
+// Shell code that records each command once it finishes with histgrep
+// record, which writes the time it started, the host, the directory it ran
+// in, its exit status and how many seconds it took, in the layout of the rich
+// format.
+func ShellHook(shell string) (string, error) {
+	switch shell {
+	case "zsh":
+		return HookMarker + `
+zmodload zsh/datetime
+_histgrep_preexec() {
+    _histgrep_cmd=$1
+    _histgrep_dir=$PWD
+    _histgrep_start=$EPOCHSECONDS
+}
+_histgrep_precmd() {
+    local histgrep_status=$?
+    [[ -n $_histgrep_cmd ]] || return
+    histgrep record --shell zsh --cwd "$_histgrep_dir" --exit $histgrep_status \
+        --duration $(( EPOCHSECONDS - _histgrep_start )) -- "$_histgrep_cmd"
+    _histgrep_cmd=
+}
+autoload -Uz add-zsh-hook
+add-zsh-hook preexec _histgrep_preexec
+add-zsh-hook precmd _histgrep_precmd
+`, nil
+	case "bash":
+		return HookMarker + `
+_histgrep_record() {
+    local histgrep_status=$? entry now
+    entry=$(HISTTIMEFORMAT='%s ' history 1)
+    if [[ $entry =~ ^[[:space:]]*([0-9]+)[[:space:]]+([0-9]+)[[:space:]](.*)$ ]] && [[ ${BASH_REMATCH[1]} != "$_histgrep_last" ]]; then
+        _histgrep_last=${BASH_REMATCH[1]}
+        printf -v now '%(%s)T' -1
+        histgrep record --shell bash --cwd "$_histgrep_dir" --exit $histgrep_status \
+            --duration $(( now - BASH_REMATCH[2] )) -- "${BASH_REMATCH[3]}"
+    fi
+    _histgrep_dir=$PWD
+    return $histgrep_status
+}
+_histgrep_dir=$PWD
+_histgrep_last=$(HISTTIMEFORMAT= history 1 | awk '{print $1}')
+PROMPT_COMMAND="_histgrep_record${PROMPT_COMMAND:+;$PROMPT_COMMAND}"
+`, nil
+	case "fish":
+		return HookMarker + `
+function _histgrep_preexec --on-event fish_preexec
+    set -g _histgrep_dir $PWD
+    set -g _histgrep_pending 1
+end
+function _histgrep_postexec --on-event fish_postexec
+    set -l histgrep_status $status
+    set -q _histgrep_pending; or return
+    set -e _histgrep_pending
+    histgrep record --shell fish --cwd $_histgrep_dir --exit $histgrep_status \
+        --duration (math -s0 $CMD_DURATION / 1000) -- $argv[1]
+end
+`, nil
+	}
+	return "", fmt.Errorf("unsupported shell %q (use one of %s)", shell, strings.Join(HookShells, ", "))
+}
@@ -0,0 +1,204 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TJN25/histgrep/hsdata"
+)
+
+// The layout used for a format's timestamp when Input.timestamp_layout is not
+// set. "unix" can be used for timestamps stored as seconds since the epoch.
+const DefaultTimeLayout = "2006-01-02 15:04:05"
+
+var absoluteLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006/01/02 15:04:05",
+}
+
+var relativeDuration = regexp.MustCompile(`^(\d+)\s*(s|sec|secs|m|min|mins|h|hr|hrs|hour|hours|d|day|days|w|week|weeks)(\s+ago)?$`)
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+var dayLayouts = []string{
+	"2006-01-02",
+	"2006/01/02",
+}
+
+// Parse a --since/--until value relative to now. Accepts absolute dates and
+// times, durations (2h, 3d, 1w, 90m ago, 1h30m), today, yesterday, now and
+// weekdays (monday, last monday). Day values refer to the start of that day.
+func ParseTimeValue(value string, now time.Time) (time.Time, error) {
+	t, _, err := parseTimeValue(value, now)
+	return t, err
+}
+
+// Parse an --until value. Day values (2024-05-01, yesterday, monday) refer to
+// the end of that day, so the day itself is included.
+func ParseUntilValue(value string, now time.Time) (time.Time, error) {
+	t, wholeDay, err := parseTimeValue(value, now)
+	if err != nil || !wholeDay {
+		return t, err
+	}
+	return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+}
+
+// Parse a time value, reporting whether it names a whole day rather than a
+// moment.
+func parseTimeValue(value string, now time.Time) (time.Time, bool, error) {
+	value = strings.TrimSpace(value)
+	// Keywords, durations and weekdays are read in any case, the layouts from
+	// the value as given (2024-01-31T15:04:05Z).
+	keyword := strings.ToLower(value)
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch keyword {
+	case "now":
+		return now, false, nil
+	case "today":
+		return startOfDay, true, nil
+	case "yesterday":
+		return startOfDay.AddDate(0, 0, -1), true, nil
+	}
+	if groups := relativeDuration.FindStringSubmatch(keyword); groups != nil {
+		count, _ := strconv.Atoi(groups[1])
+		switch groups[2][0] {
+		case 's':
+			return now.Add(-time.Duration(count) * time.Second), false, nil
+		case 'm':
+			return now.Add(-time.Duration(count) * time.Minute), false, nil
+		case 'h':
+			return now.Add(-time.Duration(count) * time.Hour), false, nil
+		case 'd':
+			return now.AddDate(0, 0, -count), false, nil
+		case 'w':
+			return now.AddDate(0, 0, -7*count), false, nil
+		}
+	}
+	if d, err := time.ParseDuration(keyword); err == nil {
+		return now.Add(-d), false, nil
+	}
+	if weekday, ok := weekdays[strings.TrimPrefix(keyword, "last ")]; ok {
+		// The most recent matching day before today.
+		back := int(startOfDay.Weekday()-weekday+7) % 7
+		if back == 0 {
+			back = 7
+		}
+		return startOfDay.AddDate(0, 0, -back), true, nil
+	}
+	for _, layout := range dayLayouts {
+		if t, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return t, true, nil
+		}
+	}
+	for _, layout := range absoluteLayouts {
+		if t, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return t, false, nil
+		}
+	}
+	if t, err := time.ParseInLocation("15:04", value, now.Location()); err == nil {
+		return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location()), false, nil
+	}
+	return time.Time{}, false, fmt.Errorf("cannot parse time %q (try 2024-01-31, 2024-01-31 15:04, 3h, 2d, yesterday or last monday)", value)
+}
+
+// The keys and layout used to read the timestamp of a line. Formats can set
+// Input.timestamp (keys joined with a space) and Input.timestamp_layout,
+// otherwise date+time, timestamp or date are used if the format has them.
+func getTimestampSpec(format_data *hsdata.FormattingData, layoutOverride string) ([]string, string, error) {
+	keys := format_data.Input["timestamp"]
+	layout := DefaultTimeLayout
+	if layouts := format_data.Input["timestamp_layout"]; len(layouts) > 0 {
+		layout = layouts[0]
+	}
+	if len(keys) == 0 {
+		inputKeys := getInputKeys(format_data)
+		if containsString(inputKeys, "date") && containsString(inputKeys, "time") {
+			keys = []string{"date", "time"}
+		} else if containsString(inputKeys, "timestamp") {
+			keys = []string{"timestamp"}
+			if len(format_data.Input["timestamp_layout"]) == 0 {
+				layout = "unix"
+			}
+		} else if containsString(inputKeys, "when") {
+			keys = []string{"when"}
+			if len(format_data.Input["timestamp_layout"]) == 0 {
+				layout = "unix"
+			}
+		} else if containsString(inputKeys, "date") {
+			keys = []string{"date"}
+			if len(format_data.Input["timestamp_layout"]) == 0 {
+				layout = "2006-01-02"
+			}
+		} else {
+			return nil, "", fmt.Errorf("the active format has no timestamp field, set Input.timestamp in formats.json")
+		}
+	}
+	if layoutOverride != "" {
+		layout = layoutOverride
+	}
+	return keys, layout, nil
+}
+
+func parseTimestamp(value string, layout string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if layout == "unix" {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(seconds, 0), nil
+	}
+	return time.ParseInLocation(layout, value, time.Local)
+}
+
+type timeFilter struct {
+	keys   []string
+	layout string
+	since  time.Time
+	until  time.Time
+}
+
+func newTimeFilter(hsDat *hsdata.HsData) (*timeFilter, error) {
+	if hsDat.Since.IsZero() && hsDat.Until.IsZero() {
+		return nil, nil
+	}
+	keys, layout, err := getTimestampSpec(&hsDat.FormatData, hsDat.TimeLayout)
+	if err != nil {
+		return nil, err
+	}
+	return &timeFilter{keys: keys, layout: layout, since: hsDat.Since, until: hsDat.Until}, nil
+}
+
+// Lines whose timestamp is missing or cannot be parsed are filtered out.
+func (tf *timeFilter) match(words MapFormat) bool {
+	values := make([]string, 0, len(tf.keys))
+	for _, key := range tf.keys {
+		values = append(values, words[key])
+	}
+	t, err := parseTimestamp(strings.Join(values, " "), tf.layout)
+	if err != nil {
+		Log.Tracef("Skipping line with unparsable timestamp %v: %v\n", values, err)
+		return false
+	}
+	if !tf.since.IsZero() && t.Before(tf.since) {
+		return false
+	}
+	if !tf.until.IsZero() && t.After(tf.until) {
+		return false
+	}
+	return true
+}
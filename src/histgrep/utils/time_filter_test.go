@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeValue(t *testing.T) {
+	now := time.Date(2024, 5, 8, 14, 30, 0, 0, time.Local)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"2024-01-31T15:04:05Z", time.Date(2024, 1, 31, 15, 4, 5, 0, time.UTC)},
+		{"2024-01-31T15:04:05+02:00", time.Date(2024, 1, 31, 13, 4, 5, 0, time.UTC)},
+		{"2024-01-31T15:04:05", time.Date(2024, 1, 31, 15, 4, 5, 0, time.Local)},
+		{" 2024-01-31 15:04 ", time.Date(2024, 1, 31, 15, 4, 0, 0, time.Local)},
+		{"Yesterday", time.Date(2024, 5, 7, 0, 0, 0, 0, time.Local)},
+		{"Last Monday", time.Date(2024, 5, 6, 0, 0, 0, 0, time.Local)},
+		{"2H", now.Add(-2 * time.Hour)},
+		{"1H30M", now.Add(-90 * time.Minute)},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimeValue(tt.value, now)
+		if err != nil {
+			t.Fatalf("ParseTimeValue(%q) failed: %v", tt.value, err)
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseTimeValue(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseUntilValue(t *testing.T) {
+	now := time.Date(2024, 5, 8, 14, 30, 0, 0, time.Local)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"2024-05-01", time.Date(2024, 5, 1, 23, 59, 59, 999999999, time.Local)},
+		{"2024/05/01", time.Date(2024, 5, 1, 23, 59, 59, 999999999, time.Local)},
+		{"yesterday", time.Date(2024, 5, 7, 23, 59, 59, 999999999, time.Local)},
+		{"2024-05-01 15:04", time.Date(2024, 5, 1, 15, 4, 0, 0, time.Local)},
+		{"2h", now.Add(-2 * time.Hour)},
+		{"2024-05-01T15:04:05Z", time.Date(2024, 5, 1, 15, 4, 5, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseUntilValue(tt.value, now)
+		if err != nil {
+			t.Fatalf("ParseUntilValue(%q) failed: %v", tt.value, err)
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseUntilValue(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestTimeFilterUntilIncludesDay(t *testing.T) {
+	now := time.Date(2024, 5, 8, 14, 30, 0, 0, time.Local)
+	until, err := ParseUntilValue("2024-05-01", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf := &timeFilter{keys: []string{"date", "time"}, layout: DefaultTimeLayout, until: until}
+	tests := []struct {
+		date, time string
+		want       bool
+	}{
+		{"2024-05-01", "00:00:00", true},
+		{"2024-05-01", "23:59:59", true},
+		{"2024-05-02", "00:00:00", false},
+	}
+	for _, tt := range tests {
+		if got := tf.match(MapFormat{"date": tt.date, "time": tt.time}); got != tt.want {
+			t.Errorf("--until 2024-05-01 on %v %v = %v, want %v", tt.date, tt.time, got, tt.want)
+		}
+	}
+}
@@ -34,13 +34,31 @@ func CallerName(skip int) string {
 	return f.Name()
 }
 
-func GetDataPath(file string) (string, error) {
-	Log.Debugf("Checking for %v\n", file)
-	searchPaths := []string{
+// The places a config file is looked for, in order.
+func DataPaths(file string) []string {
+	return []string{
 		filepath.Join(HISTGREP_CONFIG_PATH, file),
 		filepath.Join(XDG_CONFIG_HOME, "histgrep", file),
 		filepath.Join(HOME_PATH, ".histgrep", file),
 	}
+}
+
+// The path a new config file should be written to: the first search path
+// whose directory is set in the environment.
+func NewDataPath(file string) string {
+	bases := []string{HISTGREP_CONFIG_PATH, XDG_CONFIG_HOME, HOME_PATH}
+	paths := DataPaths(file)
+	for i, base := range bases {
+		if base != "" {
+			return paths[i]
+		}
+	}
+	return paths[len(paths)-1]
+}
+
+func GetDataPath(file string) (string, error) {
+	Log.Debugf("Checking for %v\n", file)
+	searchPaths := DataPaths(file)
 
 	for i, path := range searchPaths {
 		Log.Debugf("Checking path %d: %s\n", i+1, path)
@@ -65,13 +83,16 @@ func ErrorExit(msg string) {
 	os.Exit(1)
 }
 
-func FetchFormatting(file string, fm *hsdata.FormatMap) {
+func FetchFormatting(file string, fm *hsdata.FormatMap) error {
 	jsonFile, err := os.ReadFile(file)
 	if err != nil {
-		ErrorExit(fmt.Sprintf("Cannot find %v\n%v", file, err))
+		return fmt.Errorf("cannot read %v: %v", file, err)
+	}
+	if err := json.Unmarshal(jsonFile, fm); err != nil {
+		return fmt.Errorf("cannot parse %v: %v", file, err)
 	}
-	json.Unmarshal(jsonFile, fm)
 	Log.Infof("FetchFormatting: %v, from %v\n", fm, file)
+	return nil
 }
 
 func SetVerbosity(verbosity int) {
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/TJN25/histgrep/hsdata"
+)
+
+var InputTypes = []string{InputTypeZsh, InputTypeBash, InputTypeFish, InputTypeRich, InputTypePattern, InputTypeJSON}
+
+// Keys that can be coloured without coming from the input.
+var specialColorKeys = []string{"SEPARATOR", "CONTEXT", "MATCH", FileKey, LineNumberKey}
+
+// Check a format for mistakes that would otherwise be silently ignored:
+// unknown keys in Output, Color, ColorRules and Excludes, separator counts that
+// do not fit the keys, unknown colours and invalid regular expressions.
+func ValidateFormat(format_data *hsdata.FormattingData) []error {
+	var problems []error
+	report := func(msg string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(msg, args...))
+	}
+
+	inputType := getInputType(format_data)
+	if inputType != "" && !containsString(InputTypes, inputType) {
+		report("unknown Input.type %q (use one of %s)", inputType, strings.Join(InputTypes, ", "))
+		return problems
+	}
+	if err := validateInputPattern(format_data); err != nil {
+		problems = append(problems, err)
+	}
+	inputKeys := getInputKeys(format_data)
+	switch inputType {
+	case "":
+		if len(inputKeys) == 0 {
+			report("Input.keys is empty")
+		}
+		separators := format_data.Input["separators"]
+		if len(inputKeys) > 0 && len(separators) != len(inputKeys)-1 {
+			report("Input has %d keys but %d separators (expected %d)", len(inputKeys), len(separators), len(inputKeys)-1)
+		}
+	case InputTypeJSON:
+		if len(format_data.Input["keys"]) == 0 {
+			report("Input.keys is empty")
+		}
+	}
+	for _, key := range format_data.Input["timestamp"] {
+		if !containsString(inputKeys, key) {
+			report("unknown key %q in Input.timestamp", key)
+		}
+	}
+
+	outputKeys := format_data.Output["keys"]
+	if len(outputKeys) == 0 {
+		report("Output.keys is empty (use [\"BLANK\"] to show lines unchanged)")
+	} else if !isBlankOutput(format_data) {
+		for _, key := range outputKeys {
+			if !containsString(inputKeys, key) && key != FileKey && key != LineNumberKey {
+				report("unknown key %q in Output.keys", key)
+			}
+		}
+		separators := format_data.Output["separators"]
+		if len(separators) != len(outputKeys)-1 && len(separators) != len(outputKeys) {
+			report("Output has %d keys but %d separators (expected %d, or %d with a trailing separator)", len(outputKeys), len(separators), len(outputKeys)-1, len(outputKeys))
+		}
+	}
+
+	for _, key := range sortedKeys(format_data.Color) {
+		if !containsString(inputKeys, key) && !containsString(specialColorKeys, key) {
+			report("unknown key %q in Color", key)
+		}
+		color_map := format_data.Color[key]
+		for _, conditional := range sortedKeys(color_map) {
+			if _, err := ParseColor(color_map[conditional]); err != nil {
+				report("Color.%s: %v", key, err)
+			}
+			if strings.HasPrefix(conditional, RegexPrefix) {
+				if _, err := compileFormatPattern(strings.TrimPrefix(conditional, RegexPrefix)); err != nil {
+					report("Color.%s: %v", key, err)
+				}
+			}
+		}
+	}
+
+	for i, rule := range format_data.ColorRules {
+		if !containsString(inputKeys, rule.Key) {
+			report("unknown key %q in ColorRules[%d]", rule.Key, i)
+		}
+		set := 0
+		for _, value := range []string{rule.Contains, rule.StartsWith, rule.EndsWith, rule.Equals, rule.Regex} {
+			if value != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			report("ColorRules[%d] must set exactly one of %s", i, strings.Join(Conditions, ", "))
+		}
+		if rule.Regex != "" {
+			if _, err := compileFormatPattern(rule.Regex); err != nil {
+				report("ColorRules[%d]: %v", i, err)
+			}
+		}
+		if _, err := ParseColor(rule.Color); err != nil {
+			report("ColorRules[%d]: %v", i, err)
+		}
+	}
+
+	for _, key := range sortedKeys(format_data.Excludes) {
+		if !containsString(inputKeys, key) {
+			report("unknown key %q in Excludes", key)
+		} else if !containsString(outputKeys, key) {
+			report("Excludes.%s has no effect because %q is not in Output.keys", key, key)
+		}
+		excludes := format_data.Excludes[key]
+		for _, condition := range sortedKeys(excludes) {
+			if !containsString(Conditions, condition) {
+				report("unknown condition %q in Excludes.%s (use one of %s)", condition, key, strings.Join(Conditions, ", "))
+				continue
+			}
+			if condition == ConditionRegex {
+				for _, expr := range excludes[condition] {
+					if _, err := compileFormatPattern(expr); err != nil {
+						report("Excludes.%s: %v", key, err)
+					}
+				}
+			}
+		}
+	}
+	return problems
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// How a format reads and shows one line, for histgrep format test.
+type FormatTestResult struct {
+	// Each field read from the line as a key and value, in input key order.
+	Fields [][2]string
+	Output string
+	// Why the line would not be shown, if it would not.
+	Note string
+}
+
+func TestFormat(line string, format_data *hsdata.FormattingData, no_color bool) FormatTestResult {
+	result := FormatTestResult{}
+	words, mismatch := parseInputLine(line, format_data)
+	for _, key := range getInputKeys(format_data) {
+		result.Fields = append(result.Fields, [2]string{key, words[key]})
+	}
+	switch mismatch {
+	case MismatchSkip:
+		result.Note = "the line does not fit the format and is skipped (Input.on_mismatch)"
+		return result
+	case MismatchRaw:
+		result.Output = line
+		return result
+	}
+	if isBlankOutput(format_data) {
+		result.Output = line
+		return result
+	}
+	if isExcluded(&words, format_data) {
+		result.Note = "the line is removed by the format's Excludes"
+		return result
+	}
+	result.Output = FormatLine(&words, format_data, no_color)
+	return result
+}